@@ -1,28 +1,55 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"github.com/JeffreySE/ssgo/utils"
-	"github.com/go-ini/ini"
 	"gopkg.in/alecthomas/kingpin.v2"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 var (
-	app       = kingpin.New("ssgo", "A SSH-based command line tool for operating remote hosts.")
-	_         = app.HelpFlag.Short('h')
-	example   = app.Flag("example", "Show examples of ssgo's command.").Short('e').Default("false").Bool()
-	inventory = app.Flag("inventory", "For advanced use case, you can specify a host warehouse .ini file (Default is 'config.ini' file in current directory.)").Short('i').ExistingFile()
-	group     = app.Flag("group", "Remote host group name in the inventory file, which must be used with '-i' or '--inventory' argument!").Short('g').String()
-	hostFile  = app.Flag("host-file", "A file contains remote host or host range IP Address.(e.g. 'hosts.example.txt' in current directory.)").ExistingFile()
-	hostList  = app.Flag("host-list", "Remote host or host range IP Address. e.g. 192.168.10.100,192.168.10.101-192.168.10.103,192.168.20.100/28,192.168.30.11-15").String()
-	password  = app.Flag("pass", "The SSH login password for remote hosts.").Short('p').String()
-	user      = app.Flag("user", "The SSH login user for remote hosts. default is 'root'").Short('u').Default("root").String()
-	port      = app.Flag("port", "The SSH login port for remote hosts. default is '22'").Short('P').Default("22").Int()
+	app             = kingpin.New("ssgo", "A SSH-based command line tool for operating remote hosts.")
+	_               = app.HelpFlag.Short('h')
+	example         = app.Flag("example", "Show examples of ssgo's command.").Short('e').Default("false").Bool()
+	inventory       = app.Flag("inventory", "For advanced use case, you can specify a host warehouse .ini, .yaml or .json file, an ansible inventory file, an executable dynamic inventory script or a http(s) url (Default is 'config.ini' file in current directory.)").Short('i').String()
+	inventoryFormat = app.Flag("inventory-format", "The inventory format, one of ini, yaml, json, ansible-ini, ansible-yaml, ansible-json, script or http.(Default is detected by the url, file extension, executable permission and file content)").Enum(utils.InventorySourceFormats...)
+	inventoryTTL    = app.Flag("inventory-cache-ttl", "Cache time of dynamic inventory result in ~/.cache/ssgo, 0 means do not cache.").Default("5m").Duration()
+	vaultPassFile   = app.Flag("vault-password-file", "The file contains the vault password to decrypt the vault encrypted inventory file or passwords.(Default is prompted in terminal)").String()
+	group           = app.Flag("group", "Remote host group name in the inventory file, which must be used with '-i' or '--inventory' argument!").Short('g').String()
+	hostFile        = app.Flag("host-file", "A file contains remote host or host range IP Address.(e.g. 'hosts.example.txt' in current directory.)").ExistingFile()
+	hostList        = app.Flag("host-list", "Remote host or host range IP Address. e.g. 192.168.10.100,192.168.10.101-192.168.10.103,192.168.20.100/28,192.168.30.11-15").String()
+	onDuplicate     = app.Flag("on-duplicate", "How to handle duplicate hosts, one of dedupe, keep, fail or prompt.(Default is prompt if stdin is a terminal, otherwise dedupe)").Enum(utils.DuplicateModes...)
+	hostSort        = app.Flag("sort", "How to sort hosts, one of none, ip, name or random. 'ip' sorts IP Addresses numerically.(Default is none, keep the order in the inventory file or host list)").Default("none").Enum(utils.HostSortModes...)
+	password        = app.Flag("pass", "The SSH login password for remote hosts.(Default is read from SSGO_PASSWORD environment variable)").Short('p').String()
+	askPass         = app.Flag("ask-pass", "Prompt for the SSH login password in terminal, instead of --pass flag.").Short('k').Default("false").Bool()
+	askPassphrase   = app.Flag("ask-key-passphrase", "Prompt for the passphrase of the encrypted private key file in terminal.").Default("false").Bool()
+	user            = app.Flag("user", "The SSH login user for remote hosts. default is 'root'").Short('u').Default("root").String()
+	port            = app.Flag("port", "The SSH login port for remote hosts. default is '22'").Short('P').Default("22").Int()
 	//timeout           = app.Flag("timeout", "Set ssh connection timeout.").Short('t').Default("10s").Duration()
 	maxExecuteNum     = app.Flag("maxExecuteNum", "Set Maximum concurrent count of hosts.").Short('n').Default("20").Int()
+	serial            = app.Flag("serial", "Rolling execution, run hosts in sequential batches, each batch must finish before the next starts. e.g. 5, 10% or 1,5,25%(the last one is repeated).(Default is all hosts in one batch)").String()
+	pause             = app.Flag("pause", "Pause between batches of --serial, e.g. 30s.").Default("0s").Duration()
+	batchConfirm      = app.Flag("batch-confirm", "Ask for confirmation before each batch of --serial starts.").Default("false").Bool()
+	failFast          = app.Flag("fail-fast", "Stop starting new hosts once any host failed, the same as --max-failures 1.").Default("false").Bool()
+	maxFailures       = app.Flag("max-failures", "Stop starting new hosts once the failed hosts reach this number, the hosts not started are reported as skipped.(Default is 0, no limit)").Default("0").Int()
+	abortRunning      = app.Flag("abort-running", "Cancel the running hosts too when --fail-fast or --max-failures is reached.(Default is let them finish)").Default("false").Bool()
+	maxFailPercent    = app.Flag("max-fail-percentage", "Stop running the next batches of --serial if the failed hosts of a batch exceed this percentage.").Default("100").Int()
+	retries           = app.Flag("retries", "Retry N times on connection-level failures, e.g. timeout, connection refused or reset, with exponential backoff.(Default is 0, no retry)").Default("0").Int()
+	retryDelay        = app.Flag("retry-delay", "The delay before the first retry of --retries, doubled after each retry.").Default("5s").Duration()
+	retryOn           = app.Flag("retry-on", "Which failures are retried by --retries, 'connect' retries connection-level failures only, 'all' reruns the host on any failure.").Default("connect").Enum("connect", "all")
+	rerunFailed       = app.Flag("rerun-failed", "Rerun the hosts in the latest ssgo-failed-<timestamp>.txt file, which is written in current directory after each run with failed hosts and removed after the rerun. With -i/-g, --host-file or --host-list only the failed hosts of them are run.(Default is run the file as --host-file)").Default("false").Bool()
+	progress          = app.Flag("progress", "Show the progress of running hosts on stderr, one of auto, tty, plain or none. 'tty' redraws a progress line, 'plain' prints a progress line periodically.(Default is auto, tty if stdout is a terminal, otherwise plain)").Default("auto").Enum(utils.ProgressModes...)
+	progressInterval  = app.Flag("progress-interval", "The interval of plain progress lines.").Default("10s").Duration()
 	output            = app.Flag("output", "Output result'log to a file.(Be default if your input is \"log\",ssgo will output logs like \"ssgo-%s.log\")").Short('o').String()
 	formatMode        = app.Flag("format", "For pretty look in terminal,you can format the result with table,simple,json or other style.(Default is simple)").Short('F').Default("simple").String()
 	jsonRaw           = app.Flag("json-raw", "By default, the json data will be formatted and output by the console. You can specify the --json-raw parameter to output raw json data.(Default is false)").Default("false").Bool()
@@ -36,38 +63,152 @@ var (
 	cmdArgs    = run.Flag("cmd", "Specify the commands or command file you want execute on remote hosts. By default will run 'echo pong' command if nothing is specified!").Short('c').Default("").String()
 
 	sshCopy         = app.Command("copy", "Transfer files between local machine and remote hosts.")
-	copyAction      = sshCopy.Flag("action", "ssgo's copy command do upload or download operations(only accept \"upload\" or \"download\" action)").Required().Short('a').String()
-	sourcePath      = sshCopy.Flag("src", "Source file or directory path on the local machine or remote hosts").Short('s').Required().String()
+	copyAction      = sshCopy.Flag("action", "ssgo's copy command do upload, download, sync or relay operations(only accept \"upload\", \"download\", \"sync\" or \"relay\" action). \"sync\" uploads like rsync, unchanged files are skipped. \"relay\" uploads a single file to the seed hosts only, the hosts having the file send it to the other hosts.").Required().Short('a').String()
+	sourcePath      = sshCopy.Flag("src", "Source file or directory path on the local machine or remote hosts, shell-style glob patterns like \"/var/log/app/*.log\" are supported.").Short('s').Required().String()
 	destinationPath = sshCopy.Flag("dst", "Destination file or directory path on the remote host or local machine.").Short('d').Default("").String()
+	relaySeeds      = sshCopy.Flag("seeds", "The number of seed hosts for the relay action, the first hosts are uploaded from the local machine.").Default("1").Int()
+	syncChecksum    = sshCopy.Flag("checksum", "Compare files by sha256 instead of size and modification time for the sync action.").Default("false").Bool()
+	syncDelete      = sshCopy.Flag("delete", "Delete remote files absent locally for the sync action.").Default("false").Bool()
+	resume          = sshCopy.Flag("resume", "Continue the interrupted transfers from the partial files, which are written with the \".ssgo-part\" suffix until complete, the results are verified by sha256.").Default("false").Bool()
+	backup          = sshCopy.Flag("backup", "Keep the replaced remote files as \"<name>.<timestamp>.bak\" when uploading.").Default("false").Bool()
+	rollback        = sshCopy.Flag("rollback", "Restore the remote files of the upload or sync from their latest backups made by --backup instead of uploading.").Default("false").Bool()
+	preserve        = sshCopy.Flag("preserve", "Set the mode and modification time of the transferred files the same as the source files.").Default("false").Bool()
+	fileMode        = sshCopy.Flag("mode", "Set the mode of the transferred files, an octal number like 0640.").String()
+	fileOwner       = sshCopy.Flag("owner", "Set the owner and group of the transferred files like chown, e.g. app, app:app or :app, user and group names or numeric ids. Changing the ownership usually needs the login user to be root.").String()
+	layout          = sshCopy.Flag("layout", "How downloaded files are laid out in the local directory, 'prefix' saves them as \"<host>_<name>\", 'dir' saves them as \"<host>/<remote path>\", or a template like \"{{.Group}}/{{.Host}}/{{.Base}}\" with the fields Host, Group, Path, Dir and Base.").Default("prefix").String()
+	bandwidthLimit  = sshCopy.Flag("bandwidth-limit", "Limit the bandwidth of each host, e.g. 512K, 10M or 1G bytes per second.").String()
+	totalBandwidth  = sshCopy.Flag("total-bandwidth-limit", "Limit the bandwidth of all hosts together, e.g. 100M bytes per second. The relays between remote hosts of the relay action are limited by it one by one, not together.").String()
+	verify          = sshCopy.Flag("verify", "Verify the transferred files, one of none, size or sha256. 'sha256' hashes the stream while transferring and compares it with sha256sum of the remote file(or reading it back by sftp), mismatched hosts are reported as corrupt.").Default("none").Enum(utils.VerifyModes...)
+
+	inventoryCmd     = app.Command("inventory", "Manage inventory files.")
+	inventoryConvert = inventoryCmd.Command("convert", "Convert the inventory file specified by '-i' between ini, yaml and json formats.")
+	convertTo        = inventoryConvert.Flag("to", "The target inventory format, one of ini, yaml or json.(Default is detected by the --dst file extension)").Short('t').Enum(utils.InventoryFormats...)
+	convertDst       = inventoryConvert.Flag("dst", "Write the converted inventory to this file instead of the terminal.").Short('d').String()
+	inventoryCheck   = inventoryCmd.Command("validate", "Check the inventory file specified by '-i' and report errors and warnings.")
+	maxRangeSize     = inventoryCheck.Flag("max-range-size", "Warn if a host range or a hosts line contains more hosts than this.").Default("256").Int()
+	strictValidate   = inventoryCheck.Flag("strict", "Treat warnings as errors.").Default("false").Bool()
+
+	vaultCmd          = app.Command("vault", "Encrypt or decrypt inventory files and passwords.")
+	vaultEncrypt      = vaultCmd.Command("encrypt", "Encrypt inventory files, or encrypt a single value read from terminal or stdin if no file is specified.")
+	vaultEncryptFiles = vaultEncrypt.Arg("files", "The inventory files to encrypt in place.").Strings()
+	vaultDecrypt      = vaultCmd.Command("decrypt", "Decrypt inventory files and encrypted values in them, or decrypt a single value read from stdin if no file is specified.")
+	vaultDecryptFiles = vaultDecrypt.Arg("files", "The inventory files to decrypt in place.").Strings()
+	vaultDecryptDst   = vaultDecrypt.Flag("dst", "Write the decrypted file to this file instead of in place.").Short('d').String()
+	vaultDecryptOut   = vaultDecrypt.Flag("stdout", "Print the decrypted file in terminal instead of writing it.").Default("false").Bool()
+	vaultEdit         = vaultCmd.Command("edit", "Edit the encrypted inventory file with $EDITOR(Default is vi) and encrypt it again.")
+	vaultEditFile     = vaultEdit.Arg("file", "The encrypted inventory file to edit.").Required().ExistingFile()
+	vaultRekey        = vaultCmd.Command("rekey", "Change the vault password of encrypted inventory files and encrypted values in them.")
+	vaultRekeyFiles   = vaultRekey.Arg("files", "The inventory files to rekey.").Required().Strings()
+	newVaultPassFile  = vaultRekey.Flag("new-vault-password-file", "The file contains the new vault password.(Default is prompted in terminal)").String()
 )
 
 var (
 	allResultLogs []utils.ResultLogs
+	// hosts not finished successfully, written to the failed hosts file at last
+	failedHosts  []utils.FailedHost
+	hostsStarted bool
+	// 1 if the hosts can't be run by an error, see runError
+	exitCode int
+	// 1 while the hosts are running, Ctrl-C exits immediately otherwise
+	hostsRunning int32
+	// hosts of --rerun-failed file, the file is removed after the hosts are rerun
+	rerunHosts map[string]bool
+	rerunFile  string
+	// runCtx is done by the first Ctrl-C, forceCtx is done by the second one, see handleInterrupt
+	runCtx, forceCtx = context.Background(), context.Background()
 )
 
 func main() {
 	app.Version("1.0.3")
 	app.VersionFlag.Short('v')
-	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+	defer func() {
+		writeFailedHosts()
+		if runCtx.Err() != nil {
+			os.Exit(130)
+		}
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+	utils.DynamicInventoryCacheTTL = *inventoryTTL
+	utils.VaultPasswordFile = *vaultPassFile
+	utils.OnDuplicate = *onDuplicate
+	utils.HostSort = *hostSort
+	utils.TransferVerify = *verify
+	utils.SyncChecksum = *syncChecksum
+	utils.SyncDelete = *syncDelete
+	utils.TransferResume = *resume
+	utils.TransferBackup = *backup
+	utils.TransferPreserve = *preserve
+	utils.TransferOwner, utils.TransferGroup = utils.ParseOwner(*fileOwner)
+	if command == sshCopy.FullCommand() && *relaySeeds < 1 {
+		utils.ColorPrint("ERROR", "", "ERROR: ", "--seeds should be at least 1", "\n")
+		os.Exit(1)
+	}
+	if *fileMode != "" {
+		mode, err := utils.ParseFileMode(*fileMode)
+		if err != nil {
+			utils.ColorPrint("ERROR", "", "ERROR: ", err, "\n")
+			os.Exit(1)
+		}
+		utils.TransferMode = &mode
+	}
+	// the defaults of the copy flags are only set for the copy command
+	if command == sshCopy.FullCommand() {
+		if err := utils.SetDownloadLayout(*layout); err != nil {
+			utils.ColorPrint("ERROR", "", "ERROR: ", err, "\n")
+			os.Exit(1)
+		}
+	}
+	for _, limit := range []struct {
+		value string
+		dst   *int64
+	}{{*bandwidthLimit, &utils.BandwidthLimit}, {*totalBandwidth, &utils.TotalBandwidthLimit}} {
+		if limit.value == "" {
+			continue
+		}
+		bandwidth, err := utils.ParseBandwidth(limit.value)
+		if err != nil {
+			utils.ColorPrint("ERROR", "", "ERROR: ", err, "\n")
+			os.Exit(1)
+		}
+		*limit.dst = bandwidth
+	}
+	if _, err := utils.GetSerialBatches(*serial, 1); err != nil {
+		utils.ColorPrint("ERROR", "", "ERROR: ", err, "\n")
+		os.Exit(1)
+	}
+	if err := readLoginSecrets(); err != nil {
+		utils.ColorPrint("ERROR", "", "ERROR: ", err, "\n")
+		os.Exit(1)
+	}
+	// installed once for all host groups, after the login secrets are prompted
+	handleInterrupt()
+	if *retryOn == "connect" {
+		utils.ConnectRetries = *retries
+		utils.ConnectRetryDelay = *retryDelay
+	} else {
+		utils.RelaySeedRetries = *retries
+	}
+	if *rerunFailed && (command == run.FullCommand() || command == sshCopy.FullCommand()) {
+		if err := readRerunHosts(); err != nil {
+			utils.ColorPrint("ERROR", "", "ERROR: ", err, "\n")
+			os.Exit(1)
+		}
+	}
+	switch command {
 	case list.FullCommand():
 		if *example != false {
 			utils.ShowListCommandUsage()
 		} else if *inventory != "" && *group != "" {
-			cfg, err := utils.Cfg(*inventory)
+			inv, groups, err := getInventoryHostGroups()
 			if err != nil {
 				utils.ColorPrint("ERROR", ">>>", "ERROR: ", err, "\n")
+				return
 			}
-			if *group == "all" {
-				//get all hosts in config.ini file
-				for _, s := range cfg.Sections() {
-					if s.Name() == "DEFAULT" {
-						continue
-					}
-					listCommandAction(s)
-				}
-			} else {
-				s, _ := cfg.GetSection(*group)
-				listCommandAction(s)
+			for _, g := range groups {
+				listCommandAction(inv, g)
 			}
 			return
 		} else if *hostFile != "" {
@@ -92,99 +233,55 @@ func main() {
 		if *example != false {
 			utils.ShowRunCommandUsage()
 		} else if *inventory != "" && *group != "" {
-			var hosts []string
-			cfg, err := utils.Cfg(*inventory)
+			_, groups, err := getInventoryHostGroups()
 			if err != nil {
 				utils.ColorPrint("ERROR", ">>>", "ERROR: ", err, "\n")
+				return
 			}
-			if *group == "all" {
-				//get all hosts in config.ini file
-				isFinished := false
-				for index, s := range cfg.Sections() {
-					if s.Name() == "DEFAULT" {
-						continue
-					}
-
-					if s.HasKey("hosts") {
-						h, _ := s.GetKey("hosts")
-						resHosts, err := utils.GetAvailableIPFromMultiLines(h.String())
-						if err != nil {
-							utils.ColorPrint("ERROR", ">>>", "ERROR: ", err, "\n")
-							return
-						}
-						hosts = resHosts
-						userName := s.Key("user").String()
-						password := s.Key("pass").String()
-						port := s.Key("port").MustInt()
-
-						cmds, err := checkCommandArgs()
-						if err != nil {
-							utils.ColorPrint("ERROR", "", "ERROR:", err, "\n")
-							return
-						}
-						if *formatMode != "json" {
-							utils.ColorPrint("INFO", ">>> Group Name: ", "["+s.Name()+"]\n")
-						}
-						if index == len(cfg.Sections())-1 {
-							isFinished = true
-						}
-						if *scriptFile != "" {
-							doSSHCommands(userName, password, fmt.Sprintf("from hostgroup %s@%s file", s.Name(), *inventory), "", port, hosts, []string{}, *scriptFile, *scriptArgs, "script", isFinished)
-						}
-						if *cmdArgs != "" {
-							doSSHCommands(userName, password, fmt.Sprintf("from hostgroup %s@%s file", s.Name(), *inventory), "", port, hosts, cmds, "", "", "cmd", isFinished)
-						}
-
-					}
-				}
+			cmds, err := checkCommandArgs()
+			if err != nil {
+				utils.ColorPrint("ERROR", "", "ERROR:", err, "\n")
 				return
-			} else {
-				s, _ := cfg.GetSection(*group)
-				if s.HasKey("hosts") {
-					h, _ := s.GetKey("hosts")
-					resHosts, err := utils.GetAvailableIPFromMultiLines(h.String())
-					if err != nil {
-						utils.ColorPrint("ERROR", ">>>", "ERROR: ", err, "\n")
+			}
+			for index, g := range groups {
+				// the remaining groups are not run once ssgo is interrupted
+				if runCtx.Err() != nil {
+					break
+				}
+				if *formatMode != "json" {
+					utils.ColorPrint("INFO", ">>> Group Name: ", "["+g.Name+"]\n")
+				}
+				isFinished := index == len(groups)-1
+				if *scriptFile != "" {
+					if runError(doSSHCommands(fmt.Sprintf("from hostgroup %s@%s file", g.Name, *inventory), g.Hosts, []string{}, *scriptFile, *scriptArgs, "script", isFinished)) {
 						return
 					}
-					hosts = resHosts
-					userName := s.Key("user").String()
-					password := s.Key("pass").String()
-					port := s.Key("port").MustInt()
-					cmds, err := checkCommandArgs()
-					if err != nil {
-						utils.ColorPrint("ERROR", "", "ERROR:", err, "\n")
+				}
+				if *cmdArgs != "" && runCtx.Err() == nil {
+					if runError(doSSHCommands(fmt.Sprintf("from hostgroup %s@%s file", g.Name, *inventory), g.Hosts, cmds, "", "", "cmd", isFinished)) {
 						return
 					}
-					if *formatMode != "json" {
-						utils.ColorPrint("INFO", ">>> Group Name: ", "["+s.Name()+"]\n")
-					}
-					if *scriptFile != "" {
-						doSSHCommands(userName, password, fmt.Sprintf("from hostgroup %s@%s file", s.Name(), *inventory), "", port, hosts, []string{}, *scriptFile, *scriptArgs, "script", true)
-					}
-					if *cmdArgs != "" {
-						doSSHCommands(userName, password, fmt.Sprintf("from hostgroup %s@%s file", s.Name(), *inventory), "", port, hosts, cmds, "", "", "cmd", true)
-					}
 				}
 			}
 			return
 		} else if *hostFile != "" {
-			hosts, err := utils.GetAvailableIPFromFile(*hostFile)
+			ips, sources, err := utils.GetAvailableIPSourcesFromFile(*hostFile)
 			if err != nil {
 				utils.ColorPrint("ERROR", "", "ERROR:", err, "\n")
 				return
 			}
+			hosts := utils.SetHostSources(utils.NewHosts(ips, *user, *password, "", *port, ""), sources)
 			cmds, err := checkCommandArgs()
 			if err != nil {
 				utils.ColorPrint("ERROR", "", "ERROR:", err, "\n")
 				return
 			}
 			if *scriptFile != "" {
-				doSSHCommands(*user, *password, fmt.Sprintf("from file (%s)", *hostFile), "", *port, hosts, []string{}, *scriptFile, *scriptArgs, "script", true)
+				runError(doSSHCommands(fmt.Sprintf("from file (%s)", *hostFile), hosts, []string{}, *scriptFile, *scriptArgs, "script", true))
 				return
 			}
 			if *cmdArgs != "" {
-				doSSHCommands(*user, *password, fmt.Sprintf("from file (%s)", *hostFile), "", *port, hosts, cmds, "", "", "cmd", true)
+				runError(doSSHCommands(fmt.Sprintf("from file (%s)", *hostFile), hosts, cmds, "", "", "cmd", true))
 			}
 			return
 		} else if *hostList != "" {
@@ -199,11 +296,11 @@ func main() {
 				return
 			}
 			if *scriptFile != "" {
-				doSSHCommands(*user, *password, fmt.Sprintf("from list (%s)", *hostList), "", *port, hosts, []string{}, *scriptFile, *scriptArgs, "script", true)
+				runError(doSSHCommands(fmt.Sprintf("from list (%s)", *hostList), utils.NewHosts(hosts, *user, *password, "", *port, ""), []string{}, *scriptFile, *scriptArgs, "script", true))
 				return
 			}
 			if *cmdArgs != "" {
-				doSSHCommands(*user, *password, fmt.Sprintf("from list (%s)", *hostList), "", *port, hosts, cmds, "", "", "cmd", true)
+				runError(doSSHCommands(fmt.Sprintf("from list (%s)", *hostList), utils.NewHosts(hosts, *user, *password, "", *port, ""), cmds, "", "", "cmd", true))
 			}
 			return
 		} else {
@@ -213,75 +310,42 @@ func main() {
 		if *example != false {
 			utils.ShowFileTransferUsage()
 		} else if *inventory != "" && *group != "" {
-			var hosts []string
-			cfg, err := utils.Cfg(*inventory)
+			_, groups, err := getInventoryHostGroups()
 			if err != nil {
 				utils.ColorPrint("ERROR", ">>>", "ERROR: ", err, "\n")
 				return
 			}
-			if *group == "all" {
-				//get all hosts in config.ini file
-				isFinished := false
-				for index, s := range cfg.Sections() {
-					if s.Name() == "DEFAULT" {
-						continue
-					}
-					if s.HasKey("hosts") {
-						h, _ := s.GetKey("hosts")
-						resHosts, err := utils.GetAvailableIPFromMultiLines(h.String())
-						if err != nil {
-							utils.ColorPrint("ERROR", ">>>", "ERROR: ", err, "\n")
-						}
-						hosts = resHosts
-						userName := s.Key("user").String()
-						password := s.Key("pass").String()
-						port := s.Key("port").MustInt()
-						utils.ColorPrint("INFO", ">>> Group Name: ", "["+s.Name()+"]\n")
-						if index == len(cfg.Sections())-1 {
-							isFinished = true
-						}
-						if *copyAction == "upload" {
-							doSFTPFileTransfer(userName, password, s.Name(), "", port, hosts, *sourcePath, *destinationPath, "upload", isFinished)
-						} else if *copyAction == "download" {
-							doSFTPFileTransfer(userName, password, s.Name(), "", port, hosts, *sourcePath, *destinationPath, "download", isFinished)
-						} else {
-							utils.ShowFileTransferUsage()
-						}
-					}
+			for index, g := range groups {
+				// the remaining groups are not run once ssgo is interrupted
+				if runCtx.Err() != nil {
+					break
 				}
-			} else {
-				s, _ := cfg.GetSection(*group)
-				if s.HasKey("hosts") {
-					h, _ := s.GetKey("hosts")
-					resHosts, err := utils.GetAvailableIPFromMultiLines(h.String())
-					if err != nil {
-						utils.ColorPrint("ERROR", ">>>", "ERROR: ", err, "\n")
+				utils.ColorPrint("INFO", ">>> Group Name: ", "["+g.Name+"]\n")
+				isFinished := index == len(groups)-1
+				if *copyAction == "upload" || *copyAction == "sync" || *copyAction == "relay" {
+					if runError(doSFTPFileTransfer(g.Name, g.Hosts, *sourcePath, *destinationPath, uploadAction(), isFinished)) {
 						return
 					}
-					hosts = resHosts
-					userName := s.Key("user").String()
-					password := s.Key("pass").String()
-					port := s.Key("port").MustInt()
-					if *copyAction == "upload" {
-						doSFTPFileTransfer(userName, password, s.Name(), "", port, hosts, *sourcePath, *destinationPath, "upload", true)
-					} else if *copyAction == "download" {
-						doSFTPFileTransfer(userName, password, s.Name(), "", port, hosts, *sourcePath, *destinationPath, "download", true)
-					} else {
-						utils.ShowFileTransferUsage()
+				} else if *copyAction == "download" {
+					if runError(doSFTPFileTransfer(g.Name, g.Hosts, *sourcePath, *destinationPath, "download", isFinished)) {
+						return
 					}
+				} else {
+					utils.ShowFileTransferUsage()
 				}
 			}
 			return
 		} else if *hostFile != "" {
-			hosts, err := utils.GetAvailableIPFromFile(*hostFile)
+			ips, sources, err := utils.GetAvailableIPSourcesFromFile(*hostFile)
 			if err != nil {
 				utils.ColorPrint("ERROR", "", "ERROR:", err, "\n")
 				return
 			}
-			if *copyAction == "upload" {
-				doSFTPFileTransfer(*user, *password, "from-file", "", *port, hosts, *sourcePath, *destinationPath, "upload", true)
+			hosts := utils.SetHostSources(utils.NewHosts(ips, *user, *password, "", *port, ""), sources)
+			if *copyAction == "upload" || *copyAction == "sync" || *copyAction == "relay" {
+				runError(doSFTPFileTransfer("from-file", hosts, *sourcePath, *destinationPath, uploadAction(), true))
 			} else if *copyAction == "download" {
-				doSFTPFileTransfer(*user, *password, "from-file", "", *port, hosts, *sourcePath, *destinationPath, "download", true)
+				runError(doSFTPFileTransfer("from-file", hosts, *sourcePath, *destinationPath, "download", true))
 			} else {
 				utils.ShowFileTransferUsage()
 			}
@@ -292,10 +356,10 @@ func main() {
 				utils.ColorPrint("ERROR", "", "ERROR:", err, "\n")
 				return
 			}
-			if *copyAction == "upload" {
-				doSFTPFileTransfer(*user, *password, "from-list", "", *port, hosts, *sourcePath, *destinationPath, "upload", true)
+			if *copyAction == "upload" || *copyAction == "sync" || *copyAction == "relay" {
+				runError(doSFTPFileTransfer("from-list", utils.NewHosts(hosts, *user, *password, "", *port, ""), *sourcePath, *destinationPath, uploadAction(), true))
 			} else if *copyAction == "download" {
-				doSFTPFileTransfer(*user, *password, "from-list", "", *port, hosts, *sourcePath, *destinationPath, "download", true)
+				runError(doSFTPFileTransfer("from-list", utils.NewHosts(hosts, *user, *password, "", *port, ""), *sourcePath, *destinationPath, "download", true))
 			} else {
 				utils.ShowFileTransferUsage()
 			}
@@ -303,22 +367,467 @@ func main() {
 		} else {
 			utils.ShowFileTransferUsage()
 		}
+	case inventoryConvert.FullCommand():
+		if *example != false || *inventory == "" {
+			utils.ShowInventoryCommandUsage()
+			return
+		}
+		if err := inventoryConvertAction(); err != nil {
+			utils.ColorPrint("ERROR", "", "ERROR: ", err, "\n")
+			os.Exit(1)
+		}
+	case inventoryCheck.FullCommand():
+		if *example != false || *inventory == "" {
+			utils.ShowInventoryCommandUsage()
+			return
+		}
+		if !inventoryValidateAction() {
+			os.Exit(1)
+		}
+	case vaultEncrypt.FullCommand(), vaultDecrypt.FullCommand(), vaultEdit.FullCommand(), vaultRekey.FullCommand():
+		if *example != false {
+			utils.ShowVaultCommandUsage()
+			return
+		}
+		var err error
+		switch command {
+		case vaultEncrypt.FullCommand():
+			err = vaultEncryptAction()
+		case vaultDecrypt.FullCommand():
+			err = vaultDecryptAction()
+		case vaultEdit.FullCommand():
+			err = vaultEditAction()
+		case vaultRekey.FullCommand():
+			err = vaultRekeyAction()
+		}
+		if err != nil {
+			utils.ColorPrint("ERROR", "", "ERROR: ", err, "\n")
+			os.Exit(1)
+		}
 	}
 }
 
-func listCommandAction(sec *ini.Section) {
-	utils.ColorPrint("INFO", "", ">>> Group Name: ", "["+sec.Name()+"]\n")
-	if sec.HasKey("hosts") {
-		h, _ := sec.GetKey("hosts")
-		utils.ColorPrint("INFO", "", ">>> Hosts From: ", h.String(), "\n")
-		hosts, err := utils.GetAvailableIPFromMultiLines(h.String())
+// report the error which stops the hosts from running, ssgo exits with 1 after the failed hosts are written
+func runError(err error) bool {
+	if err == nil {
+		return false
+	}
+	fmt.Println(err)
+	exitCode = 1
+	return true
+}
+
+// read the hosts of the latest failed hosts file for --rerun-failed,
+// the file is used as --host-file if no other hosts are specified
+func readRerunHosts() error {
+	path, err := utils.LatestFailedHostsFile()
+	if err != nil {
+		return err
+	}
+	rerunFile = path
+	if rerunHosts, err = utils.ReadFailedHostsFile(path); err != nil {
+		return err
+	}
+	if !(*inventory != "" && *group != "") && *hostFile == "" && *hostList == "" {
+		*hostFile = path
+	}
+	if *formatMode != "json" {
+		utils.ColorPrint("INFO", "", "Tips: ", fmt.Sprintf("rerun %d failed host(s) in %s\n", len(rerunHosts), path))
+	}
+	return nil
+}
+
+// only run the hosts of the failed hosts file if --rerun-failed is specified
+func filterRerunHosts(todoHosts []utils.Host) []utils.Host {
+	if rerunHosts == nil {
+		return todoHosts
+	}
+	var hosts []utils.Host
+	for _, h := range todoHosts {
+		if rerunHosts[h.Address] {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// write the hosts not finished successfully to a new failed hosts file for --rerun-failed
+func writeFailedHosts() {
+	if rerunFile != "" && hostsStarted {
+		os.Remove(rerunFile)
+	}
+	if len(failedHosts) == 0 {
+		return
+	}
+	path, err := utils.WriteFailedHostsFile(failedHosts, strings.Join(append([]string{"ssgo"}, os.Args[1:]...), " "))
+	if *formatMode == "json" {
+		return
+	}
+	if err != nil {
+		utils.ColorPrint("ERROR", "", "ERROR: ", fmt.Sprintf("write failed hosts file failed, %s\n", err))
+		return
+	}
+	utils.ColorPrint("INFO", "", "Tips: ", fmt.Sprintf("%d host(s) not finished successfully are written to %s, rerun them by --rerun-failed or --host-file %s\n", len(failedHosts), path, path))
+}
+
+// prompt for the login password and key passphrase, they are kept in memory only
+func readLoginSecrets() error {
+	if *askPass {
+		pass, err := utils.ReadPassword("SSH password: ")
 		if err != nil {
-			utils.ColorPrint("ERROR", "", "ERROR:", err, "\n")
-			return
+			return fmt.Errorf("--ask-pass failed, %s", err)
+		}
+		*password = string(pass)
+	} else if *password == "" {
+		*password = os.Getenv("SSGO_PASSWORD")
+	}
+	if *askPassphrase {
+		passphrase, err := utils.ReadPassword("Private key passphrase: ")
+		if err != nil {
+			return fmt.Errorf("--ask-key-passphrase failed, %s", err)
+		}
+		utils.KeyPassphrase = passphrase
+	}
+	return nil
+}
+
+// resolve host groups from the inventory file by -g flag, "all" means all host groups
+func getInventoryHostGroups() (*utils.Inventory, []utils.HostGroup, error) {
+	inv, err := utils.LoadInventory(*inventory, *inventoryFormat)
+	if err != nil {
+		return inv, nil, err
+	}
+	groups, err := inv.GetHostGroups(*group)
+	if err != nil {
+		return inv, groups, err
+	}
+	// login password from --pass, --ask-pass or SSGO_PASSWORD is used if neither pass nor key is set in the inventory file
+	for _, g := range groups {
+		for i := range g.Hosts {
+			if g.Hosts[i].Password == "" && g.Hosts[i].Key == "" {
+				g.Hosts[i].Password = *password
+			}
+		}
+	}
+	return inv, groups, nil
+}
+
+func listCommandAction(inv *utils.Inventory, g utils.HostGroup) {
+	utils.ColorPrint("INFO", "", ">>> Group Name: ", "["+g.Name+"]\n")
+	if sec, err := inv.GetGroup(g.Name); err == nil {
+		if len(sec.Hosts) > 0 {
+			var lines []string
+			for _, e := range sec.Hosts {
+				lines = append(lines, e.String())
+			}
+			utils.ColorPrint("INFO", "", ">>> Hosts From: ", strings.Join(lines, "\n"), "\n")
+		}
+		if len(sec.Children) > 0 {
+			utils.ColorPrint("INFO", "", ">>> Children: ", strings.Join(sec.Children, ","), "\n")
+		}
+	}
+	utils.PrintListInventoryHosts(g.Hosts, *maxTableCellWidth)
+}
+
+// convert the inventory file to another format, print to terminal if --dst is not specified
+func inventoryConvertAction() error {
+	// the vault encrypted passwords are converted as they are, not decrypted
+	inv, err := utils.ParseInventory(*inventory, *inventoryFormat)
+	if err != nil {
+		return err
+	}
+	if err := inv.HostLineError(); err != nil {
+		return err
+	}
+	format := *convertTo
+	if format == "" {
+		if *convertDst == "" {
+			return fmt.Errorf("the target format must be specified by --to or --dst file extension")
+		}
+		format = utils.GetInventoryFormat(*convertDst)
+	}
+	buf, err := utils.EncodeInventory(inv, format)
+	if err != nil {
+		return err
+	}
+	// the converted inventory of the vault encrypted file is encrypted by the same vault password
+	if utils.IsVaultInventoryFile(*inventory) {
+		password, err := utils.GetVaultPassword()
+		if err != nil {
+			return err
+		}
+		if buf, err = utils.EncryptVaultFile(buf, password); err != nil {
+			return err
+		}
+	}
+	if *convertDst == "" {
+		fmt.Print(string(buf))
+		return nil
+	}
+	if err := ioutil.WriteFile(*convertDst, buf, 0600); err != nil {
+		return err
+	}
+	utils.ColorPrint("INFO", "", "Tips: ", fmt.Sprintf("inventory %s converted to %s(%s format)\n", *inventory, *convertDst, format))
+	return nil
+}
+
+// validate the inventory file, returns false if any error found(or any warning with --strict)
+func inventoryValidateAction() bool {
+	// the errors of host lines are reported by ValidateInventory all together
+	inv, err := utils.ParseInventory(*inventory, *inventoryFormat)
+	if err != nil {
+		utils.ColorPrint("ERROR", "", "ERROR: ", fmt.Sprintf("%s %s\n", *inventory, err))
+		return false
+	}
+	errorCount, warningCount := 0, 0
+	for _, issue := range utils.ValidateInventory(inv, *group, *maxRangeSize) {
+		if issue.Level == "ERROR" {
+			errorCount++
+		} else {
+			warningCount++
+		}
+		utils.ColorPrint(issue.Level, "", issue.Level+": ", fmt.Sprintf("%s %s\n", issue.Location(*inventory), issue.Message))
+	}
+	if errorCount == 0 && warningCount == 0 {
+		utils.ColorPrint("INFO", "", "Tips: ", fmt.Sprintf("%s is valid, %d host group(s) found.\n", *inventory, len(inv.Groups)))
+		return true
+	}
+	fmt.Printf("%d error(s), %d warning(s) found in %s\n", errorCount, warningCount, *inventory)
+	return errorCount == 0 && (warningCount == 0 || !*strictValidate)
+}
+
+// read the value to encrypt or decrypt from terminal or stdin
+func readVaultValue(prompt string) (string, error) {
+	if value, err := utils.ReadPassword(prompt); err == nil {
+		return string(value), nil
+	}
+	buf, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(buf), "\r\n"), nil
+}
+
+// keep the file mode when writing the file in place
+func writeVaultFile(path string, buf []byte) error {
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	return ioutil.WriteFile(path, buf, mode)
+}
+
+// encrypt the whole inventory files, or print the encrypted value for the inventory file, e.g. pass = $SSGO_VAULT;...
+func vaultEncryptAction() error {
+	var password []byte
+	var err error
+	if *vaultPassFile != "" {
+		password, err = utils.GetVaultPassword()
+	} else {
+		password, err = utils.NewVaultPassword("")
+	}
+	if err != nil {
+		return err
+	}
+	if len(*vaultEncryptFiles) == 0 {
+		value, err := readVaultValue("Value to encrypt: ")
+		if err != nil {
+			return err
+		}
+		encrypted, err := utils.EncryptVaultValue(value, password)
+		if err != nil {
+			return err
+		}
+		fmt.Println(encrypted)
+		return nil
+	}
+	for _, f := range *vaultEncryptFiles {
+		buf, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		if utils.IsVaultFile(buf) {
+			utils.ColorPrint("WARNING", "", "WARNING: ", fmt.Sprintf("%s is already encrypted, skipped\n", f))
+			continue
+		}
+		if buf, err = utils.EncryptVaultFile(buf, password); err != nil {
+			return err
+		}
+		if err := writeVaultFile(f, buf); err != nil {
+			return err
+		}
+		utils.ColorPrint("INFO", "", "Tips: ", fmt.Sprintf("%s encrypted\n", f))
+	}
+	return nil
+}
+
+// decrypt the whole inventory files and the encrypted values in them
+func vaultDecryptAction() error {
+	if len(*vaultDecryptFiles) == 0 {
+		value, err := readVaultValue("Value to decrypt: ")
+		if err != nil {
+			return err
+		}
+		password, err := utils.GetVaultPassword()
+		if err != nil {
+			return err
+		}
+		plain, err := utils.DecryptVaultValue(value, password)
+		if err != nil {
+			return err
+		}
+		fmt.Println(plain)
+		return nil
+	}
+	if *vaultDecryptDst != "" && len(*vaultDecryptFiles) > 1 {
+		return fmt.Errorf("--dst can only be used with one file")
+	}
+	if *vaultDecryptDst != "" && *vaultDecryptOut {
+		return fmt.Errorf("--dst and --stdout can't be used together")
+	}
+	for _, f := range *vaultDecryptFiles {
+		buf, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		password, err := utils.GetVaultPassword()
+		if err != nil {
+			return err
+		}
+		if utils.IsVaultFile(buf) {
+			if buf, err = utils.DecryptVaultFile(buf, password); err != nil {
+				return err
+			}
+		} else {
+			text, count, err := utils.ReplaceVaultValues(string(buf), func(value string) (string, error) {
+				return utils.DecryptVaultValue(value, password)
+			})
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				utils.ColorPrint("WARNING", "", "WARNING: ", fmt.Sprintf("%s is not encrypted, skipped\n", f))
+				continue
+			}
+			buf = []byte(text)
+		}
+		switch {
+		case *vaultDecryptOut:
+			fmt.Print(string(buf))
+			continue
+		case *vaultDecryptDst != "":
+			err = ioutil.WriteFile(*vaultDecryptDst, buf, 0600)
+		default:
+			err = writeVaultFile(f, buf)
+		}
+		if err != nil {
+			return err
+		}
+		utils.ColorPrint("INFO", "", "Tips: ", fmt.Sprintf("%s decrypted\n", f))
+	}
+	return nil
+}
 
+// decrypt the inventory file into a temporary file, edit it and encrypt it again
+func vaultEditAction() error {
+	buf, err := ioutil.ReadFile(*vaultEditFile)
+	if err != nil {
+		return err
+	}
+	if !utils.IsVaultFile(buf) {
+		return fmt.Errorf("%s is not encrypted, please encrypt it by 'ssgo vault encrypt' first", *vaultEditFile)
+	}
+	password, err := utils.GetVaultPassword()
+	if err != nil {
+		return err
+	}
+	plain, err := utils.DecryptVaultFile(buf, password)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile("", "ssgo-vault-*"+filepath.Ext(*vaultEditFile))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(plain)
+	tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editor+` "$0"`, tmpFile.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("run editor %s failed, %s", editor, err)
+	}
+	edited, err := ioutil.ReadFile(tmpFile.Name())
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(edited, plain) {
+		utils.ColorPrint("INFO", "", "Tips: ", fmt.Sprintf("%s is not changed\n", *vaultEditFile))
+		return nil
+	}
+	if buf, err = utils.EncryptVaultFile(edited, password); err != nil {
+		return err
+	}
+	if err := writeVaultFile(*vaultEditFile, buf); err != nil {
+		return err
+	}
+	utils.ColorPrint("INFO", "", "Tips: ", fmt.Sprintf("%s saved and encrypted\n", *vaultEditFile))
+	return nil
+}
+
+// encrypt the inventory files and the encrypted values in them with the new vault password
+func vaultRekeyAction() error {
+	password, err := utils.GetVaultPassword()
+	if err != nil {
+		return err
+	}
+	newPassword, err := utils.NewVaultPassword(*newVaultPassFile)
+	if err != nil {
+		return err
+	}
+	for _, f := range *vaultRekeyFiles {
+		buf, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		if utils.IsVaultFile(buf) {
+			plain, err := utils.DecryptVaultFile(buf, password)
+			if err != nil {
+				return err
+			}
+			if buf, err = utils.EncryptVaultFile(plain, newPassword); err != nil {
+				return err
+			}
+		} else {
+			text, count, err := utils.ReplaceVaultValues(string(buf), func(value string) (string, error) {
+				plain, err := utils.DecryptVaultValue(value, password)
+				if err != nil {
+					return "", err
+				}
+				return utils.EncryptVaultValue(plain, newPassword)
+			})
+			if err != nil {
+				return err
+			}
+			if count == 0 {
+				utils.ColorPrint("WARNING", "", "WARNING: ", fmt.Sprintf("%s is not encrypted, skipped\n", f))
+				continue
+			}
+			buf = []byte(text)
+		}
+		if err := writeVaultFile(f, buf); err != nil {
+			return err
 		}
-		utils.PrintListHosts(hosts, *maxTableCellWidth, sec.Name())
+		utils.ColorPrint("INFO", "", "Tips: ", fmt.Sprintf("%s rekeyed\n", f))
 	}
+	return nil
 }
 
 func checkCommandArgs() ([]string, error) {
@@ -344,17 +853,234 @@ func checkCommandArgs() ([]string, error) {
 	return cmds, nil
 }
 
-func doSSHCommands(user, password, hostGroupName, key string, port int, todoHosts, cmds []string, scriptFilePath, scriptArgs, action string, isFinished bool) {
+// stop starting new hosts and interrupt the running commands by the first Ctrl-C(or SIGTERM),
+// and close the connections by the second one, the partial result summary and logs are still written.
+// it's installed once before the host groups run, Ctrl-C exits immediately while no host is running, e.g. at the prompts
+func handleInterrupt() {
+	var cancel, forceCancel context.CancelFunc
+	runCtx, cancel = context.WithCancel(context.Background())
+	forceCtx, forceCancel = context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		// no host is running, e.g. at the prompts of vault password or duplicate hosts, exit immediately
+		if atomic.LoadInt32(&hostsRunning) == 0 {
+			cancel()
+			writeFailedHosts()
+			os.Exit(130)
+		}
+		fmt.Fprintln(os.Stderr, "\nInterrupted, stop starting new hosts and interrupt the running hosts, press Ctrl-C again to close the connections.")
+		cancel()
+		<-signals
+		fmt.Fprintln(os.Stderr, "\nInterrupted again, closing the connections.")
+		forceCancel()
+		// one more Ctrl-C exits immediately
+		signal.Stop(signals)
+	}()
+}
+
+// run the hosts in batches by --serial, each batch must finish before the next starts.
+// hosts are skipped if the failed hosts reach --max-failures(or --fail-fast) before a worker starts them,
+// or a batch exceeds --max-fail-percentage. running hosts are cancelled too if --abort-running is specified.
+// hosts not started are cancelled if ssgo is interrupted by Ctrl-C.
+// notRun creates the result of hosts not started with the status "skipped" or "cancelled",
+// handleResult is called with each result in order for simple format or --output, returns the result channels of all hosts
+func runHostsInBatches(todoHosts []utils.Host, run func(ctx context.Context, h utils.Host, chr chan interface{}), notRun func(h utils.Host, status, reason string) interface{}, handleResult func(i int, res interface{})) []chan interface{} {
+	atomic.StoreInt32(&hostsRunning, 1)
+	defer atomic.StoreInt32(&hostsRunning, 0)
+	hostsStarted = true
+	batches, _ := utils.GetSerialBatches(*serial, len(todoHosts))
+	maxFailed := int32(*maxFailures)
+	if *failFast {
+		maxFailed = 1
+	}
+	force, abort := context.WithCancel(forceCtx)
+	defer abort()
+	ctx, cancel := context.WithCancel(runCtx)
+	defer cancel()
+	ctx = utils.WithForceContext(ctx, force)
+	var failedTotal, skippedTotal int32
+	reachedMaxFailures := func() bool {
+		return maxFailed > 0 && atomic.LoadInt32(&failedTotal) >= maxFailed
+	}
+	maxFailuresReason := fmt.Sprintf("skipped, the failed hosts reached --max-failures %d", maxFailed)
+	if *failFast {
+		maxFailuresReason = "skipped by --fail-fast"
+	}
+	// the status and reason of hosts not started
+	notRunStatus := func() (string, string) {
+		if reachedMaxFailures() {
+			return "skipped", maxFailuresReason
+		}
+		if runCtx.Err() != nil {
+			return "cancelled", "cancelled, ssgo was interrupted"
+		}
+		return "", ""
+	}
+
+	pool := utils.NewWorkerPool(ctx, *maxExecuteNum)
+	defer pool.Close()
+	progressMode := *progress
+	if progressMode == "auto" && *formatMode == "json" {
+		progressMode = "plain"
+	}
+	display := utils.NewProgressDisplay(pool, len(todoHosts), progressMode, *progressInterval)
+	defer display.Stop()
+	chres := make([]chan interface{}, 0, len(todoHosts))
+	handleBatch := func(batchStart int) {
+		if *formatMode != "simple" && *output == "" {
+			return
+		}
+		for i := batchStart; i < len(chres); i++ {
+			res := <-chres[i]
+			display.WithoutProgress(func() {
+				handleResult(i, res)
+			})
+			// results are still needed by table and json format
+			chres[i] <- res
+		}
+	}
+	stopReason := ""
+	for b, size := range batches {
+		batchStart := len(chres)
+		batchHosts := todoHosts[batchStart : batchStart+size]
+		status, reason := notRunStatus()
+		if status == "" && stopReason != "" {
+			status, reason = "skipped", stopReason
+		}
+		if status == "" && b > 0 && !waitForNextBatch(ctx, display, b+1, len(batches)) {
+			if status, reason = notRunStatus(); status == "" {
+				stopReason = fmt.Sprintf("skipped, stopped before batch %d/%d", b+1, len(batches))
+				status, reason = "skipped", stopReason
+			}
+		}
+		if status != "" {
+			for _, host := range batchHosts {
+				chr := make(chan interface{}, 1)
+				chr <- notRun(host, status, reason)
+				chres = append(chres, chr)
+				display.AddResult(status)
+				if status == "skipped" {
+					atomic.AddInt32(&skippedTotal, 1)
+				}
+			}
+			handleBatch(batchStart)
+			continue
+		}
+
+		var failed int32
+		for _, host := range batchHosts {
+			h, chr := host, make(chan interface{}, 1)
+			chres = append(chres, chr)
+			pool.Submit(func(ctx context.Context) {
+				if status, reason := notRunStatus(); status != "" {
+					if status == "skipped" {
+						atomic.AddInt32(&skippedTotal, 1)
+					}
+					chr <- notRun(h, status, reason)
+					display.AddResult(status)
+					return
+				}
+				r := runWithRetries(ctx, h, run)
+				display.AddResult(utils.GetResultStatus(r))
+				if utils.IsFailedStatus(utils.GetResultStatus(r)) {
+					atomic.AddInt32(&failed, 1)
+					if n := atomic.AddInt32(&failedTotal, 1); *abortRunning && maxFailed > 0 && n >= maxFailed {
+						cancel()
+						abort()
+					}
+				}
+				chr <- r
+			})
+		}
+		handleBatch(batchStart)
+		pool.Wait()
+		if int(failed)*100 > size**maxFailPercent && len(chres) < len(todoHosts) {
+			stopReason = fmt.Sprintf("skipped, %d of %d hosts failed in batch %d/%d, more than --max-fail-percentage %d%%", failed, size, b+1, len(batches), *maxFailPercent)
+		}
+	}
+	display.Stop()
+	if stopReason == "" {
+		stopReason = maxFailuresReason
+	}
+	if skippedTotal > 0 && *formatMode != "json" {
+		utils.ColorPrint("ERROR", "", "ERROR: ", fmt.Sprintf("%d host(s) failed, %d host(s) %s\n", failedTotal, skippedTotal, stopReason))
+	}
+	for i, chr := range chres {
+		res := <-chr
+		if status := utils.GetResultStatus(res); status != "success" {
+			failedHosts = append(failedHosts, utils.FailedHost{Address: todoHosts[i].Address, Status: status})
+		}
+		chr <- res
+	}
+	return chres
+}
+
+// run the host, and rerun it on any failure by --retries if --retry-on is "all"
+func runWithRetries(ctx context.Context, h utils.Host, run func(ctx context.Context, h utils.Host, chr chan interface{})) interface{} {
+	res := make(chan interface{}, 1)
+	run(ctx, h, res)
+	r := <-res
+	if *retryOn != "all" {
+		return r
+	}
+	for retry := 0; retry < *retries && utils.IsFailedStatus(utils.GetResultStatus(r)); retry++ {
+		if !utils.WaitForRetry(ctx, *retryDelay, retry) {
+			break
+		}
+		run(ctx, h, res)
+		r = <-res
+	}
+	return r
+}
+
+// wait for --pause or --batch-confirm before the next batch starts, returns false if the user stops it or ssgo is interrupted
+func waitForNextBatch(ctx context.Context, display *utils.ProgressDisplay, next, total int) bool {
+	display.Suspend()
+	defer display.Resume()
+	if *pause > 0 {
+		if *formatMode != "json" {
+			utils.ColorPrint("INFO", "", "Tips: ", fmt.Sprintf("pause %s before batch %d/%d\n", *pause, next, total))
+		}
+		select {
+		case <-time.After(*pause):
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if !*batchConfirm {
+		return true
+	}
+	answer := make(chan bool, 1)
+	go func() {
+		for retry := 0; retry < 3; retry++ {
+			ok, err := utils.Confirm(fmt.Sprintf("Continue with batch %d/%d? (y/n) ", next, total))
+			if err == nil {
+				answer <- ok
+				return
+			}
+		}
+		answer <- false
+	}()
+	select {
+	case ok := <-answer:
+		return ok
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// run the commands or script on the hosts, the error is returned if the hosts can't be run, e.g. duplicate hosts are rejected
+func doSSHCommands(hostGroupName string, todoHosts []utils.Host, cmds []string, scriptFilePath, scriptArgs, action string, isFinished bool) error {
 	var resultLog utils.ResultLogs
 	if len(cmds) == 0 {
 		cmds = append(cmds, "echo pong")
 	}
-	todoHosts, err := utils.DuplicateIPAddressCheck(todoHosts)
+	todoHosts, err := utils.DuplicateHostCheck(filterRerunHosts(todoHosts))
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
 	}
-	pool := utils.NewPool(*maxExecuteNum, len(todoHosts))
 	startTime := time.Now()
 	resultLog.StartTime = startTime.Format("2006-01-02 15:04:05")
 	resultLog.HostGroup = hostGroupName
@@ -365,32 +1091,22 @@ func doSSHCommands(user, password, hostGroupName, key string, port int, todoHost
 	if *output != "" {
 		utils.WriteAndAppendFile(*output, fmt.Sprintf("Tips: process running start: %s", resultLog.StartTime))
 	}
-	chres := make([]chan interface{}, len(todoHosts))
-	for i, host := range todoHosts {
-		chres[i] = make(chan interface{}, 1)
-		go func(h string, a string, chr chan interface{}) {
-			pool.AddOne()
-			switch a {
-			case "script":
-				utils.SSHRunShellScript(user, password, h, key, scriptFilePath, scriptArgs, port, chr)
-			case "cmd":
-				utils.DoSSHRunFast(user, password, h, key, cmds, port, chr)
-			}
-			pool.DelOne()
-		}(host, action, chres[i])
-		if *formatMode == "simple" || *output != "" {
-			res := <-chres[i]
-			if res.(utils.SSHResult).Status == "failed" {
-				resultLog.ErrorHosts = append(resultLog.ErrorHosts, res)
-			} else {
-				resultLog.SuccessHosts = append(resultLog.SuccessHosts, res)
-			}
-			utils.FormatResultWithBasicStyle(i, res.(utils.SSHResult))
-			if *output != "" {
-				utils.LogSSHResultToFile(i, res.(utils.SSHResult), *output)
-			}
+	chres := runHostsInBatches(todoHosts, func(ctx context.Context, h utils.Host, chr chan interface{}) {
+		switch action {
+		case "script":
+			utils.SSHRunShellScript(ctx, h, scriptFilePath, scriptArgs, chr)
+		case "cmd":
+			utils.DoSSHRunFast(ctx, h, cmds, chr)
 		}
-	}
+	}, func(h utils.Host, status, reason string) interface{} {
+		return utils.SSHResult{Host: h.Address, Status: status, Result: reason}
+	}, func(i int, res interface{}) {
+		utils.AddResultToLog(&resultLog, res)
+		utils.FormatResultWithBasicStyle(i, res.(utils.SSHResult))
+		if *output != "" {
+			utils.LogSSHResultToFile(i, res.(utils.SSHResult), *output)
+		}
+	})
 	switch *formatMode {
 	case "simple":
 		utils.FormatResultLogWithSimpleStyle(resultLog, startTime, *maxTableCellWidth, []string{"Result"})
@@ -410,17 +1126,30 @@ func doSSHCommands(user, password, hostGroupName, key string, port int, todoHost
 	if *output != "" {
 		utils.ResultLogInfo(resultLog, startTime, true, *output)
 	}
-	pool.Wg.Wait()
+	return nil
 }
 
-func doSFTPFileTransfer(user, password, hostGroupName, key string, port int, todoHosts []string, sourcePath, destinationPath, action string, isFinished bool) {
+// the action of "upload", "sync" or "relay", it's "rollback" by --rollback
+func uploadAction() string {
+	if *rollback {
+		return "rollback"
+	}
+	return *copyAction
+}
+
+// transfer the files of the hosts, the error is returned if the hosts can't be run like doSSHCommands
+func doSFTPFileTransfer(hostGroupName string, todoHosts []utils.Host, sourcePath, destinationPath, action string, isFinished bool) error {
 	var resultLog utils.ResultLogs
-	todoHosts, err := utils.DuplicateIPAddressCheck(todoHosts)
+	todoHosts, err := utils.DuplicateHostCheck(filterRerunHosts(todoHosts))
 	if err != nil {
-		fmt.Println(err)
-		return
+		return err
+	}
+	var relayTree *utils.RelayTree
+	if action == "relay" {
+		if relayTree, err = utils.NewRelayTree(sourcePath, todoHosts, *relaySeeds); err != nil {
+			return err
+		}
 	}
-	pool := utils.NewPool(*maxExecuteNum, len(todoHosts))
 	startTime := time.Now()
 	resultLog.StartTime = startTime.Format("2006-01-02 15:04:05")
 	resultLog.HostGroup = hostGroupName
@@ -430,32 +1159,28 @@ func doSFTPFileTransfer(user, password, hostGroupName, key string, port int, tod
 	if *output != "" {
 		utils.WriteAndAppendFile(*output, fmt.Sprintf("Tips: process running start: %s", resultLog.StartTime))
 	}
-	chres := make([]chan interface{}, len(todoHosts))
-	for i, host := range todoHosts {
-		chres[i] = make(chan interface{}, 1)
-		go func(h string, a, s, d string, chr chan interface{}) {
-			pool.AddOne()
-			switch a {
-			case "upload":
-				utils.SFTPUpload(user, password, h, key, port, s, d, chr)
-			case "download":
-				utils.SFTPDownload(user, password, h, key, port, s, d, chr)
-			}
-			pool.DelOne()
-		}(host, action, sourcePath, destinationPath, chres[i])
-		if *formatMode == "simple" || *output != "" {
-			res := <-chres[i]
-			if res.(utils.SFTPResult).Status == "failed" {
-				resultLog.ErrorHosts = append(resultLog.ErrorHosts, res)
-			} else {
-				resultLog.SuccessHosts = append(resultLog.SuccessHosts, res)
-			}
-			utils.SFTPFormatResultWithBasicStyle(i, res.(utils.SFTPResult))
-			if *output != "" {
-				utils.LogSFTPResultToFile(i, res.(utils.SFTPResult), *output)
-			}
+	chres := runHostsInBatches(todoHosts, func(ctx context.Context, h utils.Host, chr chan interface{}) {
+		switch action {
+		case "upload":
+			utils.SFTPUpload(ctx, h, sourcePath, destinationPath, chr)
+		case "sync":
+			utils.SFTPSync(ctx, h, sourcePath, destinationPath, chr)
+		case "rollback":
+			utils.SFTPRollback(ctx, h, sourcePath, destinationPath, chr)
+		case "relay":
+			utils.SFTPRelay(ctx, h, relayTree, destinationPath, chr)
+		case "download":
+			utils.SFTPDownload(ctx, h, sourcePath, destinationPath, chr)
 		}
-	}
+	}, func(h utils.Host, status, reason string) interface{} {
+		return utils.SFTPResult{Host: h.Address, Status: status, SourcePath: sourcePath, DestinationPath: destinationPath, Result: reason}
+	}, func(i int, res interface{}) {
+		utils.AddResultToLog(&resultLog, res)
+		utils.SFTPFormatResultWithBasicStyle(i, res.(utils.SFTPResult))
+		if *output != "" {
+			utils.LogSFTPResultToFile(i, res.(utils.SFTPResult), *output)
+		}
+	})
 	switch *formatMode {
 	case "simple":
 		utils.FormatResultLogWithSimpleStyle(resultLog, startTime, *maxTableCellWidth, []string{})
@@ -475,5 +1200,5 @@ func doSFTPFileTransfer(user, password, hostGroupName, key string, port int, tod
 	if *output != "" {
 		utils.ResultLogInfo(resultLog, startTime, true, *output)
 	}
-	pool.Wg.Wait()
+	return nil
 }
@@ -0,0 +1,504 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ansible host variables and the ssgo login variables they map to
+var ansibleHostVarKeys = map[string]string{
+	"ansible_user":                 "user",
+	"ansible_ssh_user":             "user",
+	"ansible_password":             "pass",
+	"ansible_ssh_pass":             "pass",
+	"ansible_ssh_password":         "pass",
+	"ansible_port":                 "port",
+	"ansible_ssh_port":             "port",
+	"ansible_private_key_file":     "key",
+	"ansible_ssh_private_key_file": "key",
+}
+
+// ProxyJump in ansible_ssh_common_args or ansible_ssh_extra_args, e.g. "-o ProxyJump=admin@192.168.100.1" or "-J admin@192.168.100.1"
+var ansibleProxyJumpRegexp = regexp.MustCompile(`(?:ProxyJump[= ]|-J\s*)["']?([^\s"',]+)`)
+
+// map ansible variables to ssgo login variables, other variables are ignored
+func ansibleVars(vars map[string]interface{}) map[string]string {
+	ret := map[string]string{}
+	for k, v := range vars {
+		if key, ok := ansibleHostVarKeys[k]; ok {
+			ret[key] = fmt.Sprint(v)
+		}
+	}
+	for _, k := range []string{"ansible_ssh_common_args", "ansible_ssh_extra_args"} {
+		if v, ok := vars[k]; ok {
+			if m := ansibleProxyJumpRegexp.FindStringSubmatch(fmt.Sprint(v)); m != nil {
+				ret["jump"] = m[1]
+			}
+		}
+	}
+	return ret
+}
+
+// the address of an ansible host is ansible_host if specified, otherwise the host name, which must be
+// a valid host name or IP address then
+func ansibleHostEntry(name string, vars map[string]interface{}) InventoryHostEntry {
+	entry := InventoryHostEntry{Expr: name, Vars: ansibleVars(vars)}
+	if h, ok := vars["ansible_host"]; ok && fmt.Sprint(h) != "" {
+		entry.Expr = fmt.Sprint(h)
+	} else if !CheckIp(name) && !CheckHostName(name) {
+		entry.Err = fmt.Errorf("ERROR: '%s' is not a valid host name or IP address, please set ansible_host for it", name)
+	}
+	return entry
+}
+
+// split the port of ansible host pattern like "web01:2222", "web[01:10]:2222" or "[2001:db8::1]:2222",
+// the colons of host ranges and IPv6 addresses are not the port separator
+func splitAnsibleHostPort(pattern string) (string, string) {
+	i := strings.LastIndex(pattern, ":")
+	if i < 0 || CheckIp(pattern) {
+		return pattern, ""
+	}
+	host, port := pattern[:i], pattern[i+1:]
+	if _, err := strconv.Atoi(port); err != nil {
+		return pattern, ""
+	}
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") && CheckIp(host[1:len(host)-1]) {
+		return host[1 : len(host)-1], port
+	}
+	// a colon out of the host ranges, e.g. "fe80::1", isn't a host name with port
+	if strings.Contains(ansibleHostRangeRegexp.ReplaceAllString(host, ""), ":") {
+		return pattern, ""
+	}
+	return host, port
+}
+
+// the host ranges of ansible host pattern, e.g. "[01:50]" in "www[01:50].example.com"
+var ansibleHostRangeRegexp = regexp.MustCompile(`\[[^\]]*\]`)
+
+// expand the ansible host pattern with its port, the port is set as ansible_port unless it's in vars
+func expandAnsibleHost(pattern string, vars map[string]interface{}) ([]string, map[string]interface{}, error) {
+	pattern, port := splitAnsibleHostPort(pattern)
+	hosts, err := ExpandAnsibleHostPattern(pattern)
+	if err != nil || port == "" {
+		return hosts, vars, err
+	}
+	withPort := map[string]interface{}{"ansible_port": port}
+	for k, v := range vars {
+		withPort[k] = v
+	}
+	return hosts, withPort, nil
+}
+
+// group of ansible json inventory, a list of hosts or an object with hosts, vars and children
+type ansibleJSONGroup struct {
+	Hosts    []string               `json:"hosts"`
+	Vars     map[string]interface{} `json:"vars"`
+	Children []string               `json:"children"`
+}
+
+// parse the json output of ansible dynamic inventory, e.g. "inventory.py --list"
+// https://docs.ansible.com/ansible/latest/dev_guide/developing_inventory.html
+func ParseAnsibleJSONInventory(buf []byte, source string) (*Inventory, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		return nil, fmt.Errorf("failed to read dynamic inventory from %s, please check:%v", source, err)
+	}
+	var meta struct {
+		HostVars map[string]map[string]interface{} `json:"hostvars"`
+	}
+	if m, ok := raw["_meta"]; ok {
+		if err := json.Unmarshal(m, &meta); err != nil {
+			return nil, fmt.Errorf("failed to read _meta of dynamic inventory from %s, please check:%v", source, err)
+		}
+	}
+	var names []string
+	for name := range raw {
+		if name != "_meta" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	inv := &Inventory{Path: source, Vars: map[string]string{}}
+	var ungrouped []InventoryHostEntry
+	for _, name := range names {
+		var g ansibleJSONGroup
+		if err := json.Unmarshal(raw[name], &g.Hosts); err != nil {
+			if err := json.Unmarshal(raw[name], &g); err != nil {
+				return nil, fmt.Errorf("failed to read host group '%s' of dynamic inventory from %s, please check:%v", name, source, err)
+			}
+		}
+		var entries []InventoryHostEntry
+		for _, h := range g.Hosts {
+			entries = append(entries, ansibleHostEntry(h, meta.HostVars[h]))
+		}
+		if name == inventoryAllGroup {
+			// the hosts of 'all' group are ungrouped hosts, and its vars are inherited by every group
+			inv.Vars = ansibleVars(g.Vars)
+			ungrouped = entries
+			continue
+		}
+		inv.Groups = append(inv.Groups, &InventoryGroup{Name: name, Vars: ansibleVars(g.Vars), Hosts: entries, Children: g.Children})
+	}
+	if len(ungrouped) > 0 {
+		if g, err := inv.GetGroup("ungrouped"); err == nil {
+			g.Hosts = append(g.Hosts, ungrouped...)
+		} else {
+			inv.Groups = append(inv.Groups, &InventoryGroup{Name: "ungrouped", Vars: map[string]string{}, Hosts: ungrouped})
+		}
+	}
+	return inv, nil
+}
+
+// collect ansible groups and host variables, hosts variables are merged across groups like ansible does
+type ansibleInventoryBuilder struct {
+	inv        *Inventory
+	groupHosts map[string][]ansibleHostRef
+	hostVars   map[string]map[string]interface{}
+}
+
+// host name and the line number where it's defined
+type ansibleHostRef struct {
+	Name string
+	Line int
+}
+
+func newAnsibleInventoryBuilder(source string) *ansibleInventoryBuilder {
+	return &ansibleInventoryBuilder{
+		inv:        &Inventory{Path: source, Vars: map[string]string{}},
+		groupHosts: map[string][]ansibleHostRef{},
+		hostVars:   map[string]map[string]interface{}{},
+	}
+}
+
+func (b *ansibleInventoryBuilder) group(name string) *InventoryGroup {
+	if g, err := b.inv.GetGroup(name); err == nil {
+		return g
+	}
+	g := &InventoryGroup{Name: name, Vars: map[string]string{}}
+	b.inv.Groups = append(b.inv.Groups, g)
+	return g
+}
+
+func (b *ansibleInventoryBuilder) addHost(groupName, hostName string, line int, vars map[string]interface{}) {
+	if groupName == inventoryAllGroup {
+		groupName = "ungrouped"
+	}
+	b.group(groupName)
+	b.groupHosts[groupName] = append(b.groupHosts[groupName], ansibleHostRef{Name: hostName, Line: line})
+	if b.hostVars[hostName] == nil {
+		b.hostVars[hostName] = map[string]interface{}{}
+	}
+	for k, v := range vars {
+		b.hostVars[hostName][k] = v
+	}
+}
+
+func (b *ansibleInventoryBuilder) addVars(groupName string, vars map[string]interface{}) {
+	target := b.inv.Vars
+	if groupName != inventoryAllGroup {
+		target = b.group(groupName).Vars
+	}
+	for k, v := range ansibleVars(vars) {
+		target[k] = v
+	}
+}
+
+func (b *ansibleInventoryBuilder) addChild(groupName, child string) {
+	b.group(child)
+	if groupName == inventoryAllGroup {
+		return
+	}
+	g := b.group(groupName)
+	for _, c := range g.Children {
+		if c == child {
+			return
+		}
+	}
+	g.Children = append(g.Children, child)
+}
+
+func (b *ansibleInventoryBuilder) build() *Inventory {
+	for _, g := range b.inv.Groups {
+		for _, h := range b.groupHosts[g.Name] {
+			entry := ansibleHostEntry(h.Name, b.hostVars[h.Name])
+			entry.Line = h.Line
+			g.Hosts = append(g.Hosts, entry)
+		}
+	}
+	return b.inv
+}
+
+// expand ansible host pattern like "www[01:50].example.com", "db-[a:f].example.com" or "web[1:10:2]"
+func ExpandAnsibleHostPattern(pattern string) ([]string, error) {
+	start := strings.Index(pattern, "[")
+	end := strings.Index(pattern, "]")
+	if start < 0 || end < start {
+		return []string{pattern}, nil
+	}
+	prefix, rangeStr, suffix := pattern[:start], pattern[start+1:end], pattern[end+1:]
+	parts := strings.Split(rangeStr, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("ERROR: invalid host range '%s' in '%s', e.g. www[01:50].example.com", rangeStr, pattern)
+	}
+	step := 1
+	if len(parts) == 3 {
+		s, err := strconv.Atoi(parts[2])
+		if err != nil || s < 1 {
+			return nil, fmt.Errorf("ERROR: invalid host range step '%s' in '%s'", parts[2], pattern)
+		}
+		step = s
+	}
+	var items []string
+	if from, err := strconv.Atoi(parts[0]); err == nil {
+		to, err := strconv.Atoi(parts[1])
+		if err != nil || to < from {
+			return nil, fmt.Errorf("ERROR: invalid host range '%s' in '%s'", rangeStr, pattern)
+		}
+		// keep leading zeros, e.g. [01:50]
+		format := "%d"
+		if len(parts[0]) > 1 && strings.HasPrefix(parts[0], "0") {
+			format = fmt.Sprintf("%%0%dd", len(parts[0]))
+		}
+		for i := from; i <= to; i += step {
+			items = append(items, fmt.Sprintf(format, i))
+		}
+	} else if len(parts[0]) == 1 && len(parts[1]) == 1 && parts[0] <= parts[1] {
+		for c := parts[0][0]; c <= parts[1][0]; c += byte(step) {
+			items = append(items, string(c))
+		}
+	} else {
+		return nil, fmt.Errorf("ERROR: invalid host range '%s' in '%s'", rangeStr, pattern)
+	}
+	var hosts []string
+	for _, item := range items {
+		// the suffix may contains another range
+		expanded, err := ExpandAnsibleHostPattern(prefix + item + suffix)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, expanded...)
+	}
+	return hosts, nil
+}
+
+// split a line of ansible ini inventory like `web01 ansible_user=admin ansible_ssh_common_args="-o ProxyJump=jump"`
+func splitAnsibleLine(line string) []string {
+	var fields []string
+	var current strings.Builder
+	var quote rune
+	hasField := false
+	for _, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			hasField = true
+		case c == '#' && !hasField:
+			// the rest of line is comment
+			return fields
+		case unicode.IsSpace(c):
+			if hasField {
+				fields = append(fields, current.String())
+				current.Reset()
+				hasField = false
+			}
+		default:
+			current.WriteRune(c)
+			hasField = true
+		}
+	}
+	if hasField {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
+
+// parse ansible ini inventory, supports [group], [group:vars] and [group:children] sections
+// https://docs.ansible.com/ansible/latest/user_guide/intro_inventory.html
+func ParseAnsibleINIInventory(buf []byte, source string) (*Inventory, error) {
+	b := newAnsibleInventoryBuilder(source)
+	groupName, sectionType := "ungrouped", "hosts"
+	for i, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			groupName, sectionType = line[1:len(line)-1], "hosts"
+			if j := strings.LastIndex(groupName, ":"); j > -1 {
+				groupName, sectionType = groupName[:j], groupName[j+1:]
+			}
+			if sectionType != "hosts" && sectionType != "vars" && sectionType != "children" {
+				return nil, fmt.Errorf("ERROR: invalid section '%s' at line %d of %s", line, i+1, source)
+			}
+			if groupName != inventoryAllGroup {
+				b.group(groupName)
+			}
+			continue
+		}
+		switch sectionType {
+		case "vars":
+			kv := strings.SplitN(line, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("ERROR: invalid variable '%s' at line %d of %s, e.g. ansible_user=root", line, i+1, source)
+			}
+			value := strings.TrimSpace(kv[1])
+			if fields := splitAnsibleLine(value); len(fields) == 1 {
+				value = fields[0]
+			}
+			b.addVars(groupName, map[string]interface{}{strings.TrimSpace(kv[0]): value})
+		case "children":
+			b.addChild(groupName, line)
+		default:
+			fields := splitAnsibleLine(line)
+			if len(fields) == 0 {
+				continue
+			}
+			vars := map[string]interface{}{}
+			for _, field := range fields[1:] {
+				kv := strings.SplitN(field, "=", 2)
+				if len(kv) != 2 {
+					return nil, fmt.Errorf("ERROR: invalid host variable '%s' at line %d of %s, e.g. ansible_port=22", field, i+1, source)
+				}
+				vars[kv[0]] = kv[1]
+			}
+			hosts, vars, err := expandAnsibleHost(fields[0], vars)
+			if err != nil {
+				return nil, fmt.Errorf("%s at line %d of %s", err, i+1, source)
+			}
+			for _, h := range hosts {
+				b.addHost(groupName, h, i+1, vars)
+			}
+		}
+	}
+	return b.build(), nil
+}
+
+// group of ansible yaml inventory
+type ansibleYAMLGroup struct {
+	Hosts    yaml.MapSlice          `yaml:"hosts"`
+	Vars     map[string]interface{} `yaml:"vars"`
+	Children yaml.MapSlice          `yaml:"children"`
+}
+
+// parse ansible yaml inventory, the top level keys are group names, usually 'all'
+func ParseAnsibleYAMLInventory(buf []byte, source string) (*Inventory, error) {
+	var groups yaml.MapSlice
+	if err := yaml.Unmarshal(buf, &groups); err != nil {
+		return nil, fmt.Errorf("failed to read ansible yaml inventory file,please check:%v", err)
+	}
+	b := newAnsibleInventoryBuilder(source)
+	for _, item := range groups {
+		if err := b.addYAMLGroup(fmt.Sprint(item.Key), item.Value); err != nil {
+			return nil, err
+		}
+	}
+	return b.build(), nil
+}
+
+func (b *ansibleInventoryBuilder) addYAMLGroup(name string, value interface{}) error {
+	var g ansibleYAMLGroup
+	// re-decode the group node, yaml.v2 decodes nested maps as map[interface{}]interface{}
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(out, &g); err != nil {
+		return fmt.Errorf("failed to read host group '%s' of %s, please check:%v", name, b.inv.Path, err)
+	}
+	if name != inventoryAllGroup {
+		b.group(name)
+	}
+	b.addVars(name, g.Vars)
+	for _, h := range g.Hosts {
+		vars, _ := documentMap(h.Value)
+		hosts, vars, err := expandAnsibleHost(fmt.Sprint(h.Key), vars)
+		if err != nil {
+			return fmt.Errorf("%s in host group '%s' of %s", err, name, b.inv.Path)
+		}
+		for _, host := range hosts {
+			b.addHost(name, host, 0, vars)
+		}
+	}
+	for _, c := range g.Children {
+		child := fmt.Sprint(c.Key)
+		b.addChild(name, child)
+		if err := b.addYAMLGroup(child, c.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ssgo ini inventory sections contain "key = value" lines only(except the multi lines hosts block),
+// ansible ini inventory has host lines or [group:vars], [group:children] sections
+func IsAnsibleINIInventory(buf []byte) bool {
+	inHostsBlock := false
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.Count(line, `"""`)%2 == 1 {
+			inHostsBlock = !inHostsBlock
+			continue
+		}
+		if inHostsBlock || line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if strings.HasSuffix(line, ":vars]") || strings.HasSuffix(line, ":children]") {
+				return true
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		if !strings.Contains(fields[0], "=") && (len(fields) == 1 || !strings.HasPrefix(fields[1], "=")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ssgo yaml inventory has a top level 'groups' list
+func IsAnsibleYAMLInventory(buf []byte) bool {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return false
+	}
+	_, ok := doc["groups"]
+	return !ok && len(doc) > 0
+}
+
+// ansible json inventory, e.g. the output of "ansible-inventory --list", has no top level 'groups' list
+func IsAnsibleJSONInventory(buf []byte) bool {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return false
+	}
+	_, ok := doc["groups"]
+	return !ok && len(doc) > 0
+}
+
+func loadAnsibleInventory(inventoryFilePath, format string) (*Inventory, error) {
+	buf, err := ReadInventoryFile(inventoryFilePath)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "ansible-yaml":
+		return ParseAnsibleYAMLInventory(buf, inventoryFilePath)
+	case "ansible-json":
+		return ParseAnsibleJSONInventory(buf, inventoryFilePath)
+	}
+	return ParseAnsibleINIInventory(buf, inventoryFilePath)
+}
@@ -0,0 +1,266 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandAnsibleHostPattern(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+		err  bool
+	}{
+		{"web01.example.com", []string{"web01.example.com"}, false},
+		{"www[01:03].example.com", []string{"www01.example.com", "www02.example.com", "www03.example.com"}, false},
+		{"web[8:10]", []string{"web8", "web9", "web10"}, false},
+		{"web[1:10:4]", []string{"web1", "web5", "web9"}, false},
+		{"db-[a:c].example.com", []string{"db-a.example.com", "db-b.example.com", "db-c.example.com"}, false},
+		{"rack[1:2]-node[a:b]", []string{"rack1-nodea", "rack1-nodeb", "rack2-nodea", "rack2-nodeb"}, false},
+		{"192.168.100.[1:3]", []string{"192.168.100.1", "192.168.100.2", "192.168.100.3"}, false},
+		{"web[3:1]", nil, true},
+		{"web[1]", nil, true},
+		{"web[1:2:3:4]", nil, true},
+		{"web[1:5:0]", nil, true},
+		{"web[a:10]", nil, true},
+		{"web[c:a]", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ExpandAnsibleHostPattern(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("ExpandAnsibleHostPattern(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ExpandAnsibleHostPattern(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplitAnsibleLine(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"web01", []string{"web01"}},
+		{"web01  ansible_port=2222 ansible_user=admin", []string{"web01", "ansible_port=2222", "ansible_user=admin"}},
+		{`web01 ansible_ssh_common_args="-o ProxyJump=admin@10.0.0.1"`, []string{"web01", "ansible_ssh_common_args=-o ProxyJump=admin@10.0.0.1"}},
+		{"web01 ansible_password='p a#ss'", []string{"web01", "ansible_password=p a#ss"}},
+		{"web01 # the web server", []string{"web01"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := splitAnsibleLine(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitAnsibleLine(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseAnsibleINIInventory(t *testing.T) {
+	buf := []byte(`# ansible inventory
+192.168.100.9
+
+[web]
+192.168.100.[1:2] ansible_user=admin
+192.168.100.3 ansible_port=2222 ansible_ssh_pass='p a#ss'
+
+[db]
+db01 ansible_host=192.168.100.5 ansible_ssh_common_args="-o ProxyJump=jump@192.168.100.254"
+
+[db:vars]
+ansible_user=dba
+ansible_ssh_private_key_file=~/.ssh/db.pem
+
+[prod:children]
+web
+db
+
+[all:vars]
+ansible_user=root
+ansible_password=secret
+ansible_port=22
+`)
+	inv, err := ParseAnsibleINIInventory(buf, "hosts")
+	if err != nil {
+		t.Fatalf("ParseAnsibleINIInventory failed, %s", err)
+	}
+	if got, want := inv.GroupNames(), []string{"ungrouped", "web", "db", "prod"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("group names = %v, want %v", got, want)
+	}
+	tests := []struct {
+		group string
+		want  []string
+	}{
+		{"ungrouped", []string{"192.168.100.9 root:secret@22  "}},
+		{"web", []string{
+			"192.168.100.1 admin:secret@22  ",
+			"192.168.100.2 admin:secret@22  ",
+			"192.168.100.3 root:p a#ss@2222  ",
+		}},
+		{"db", []string{"192.168.100.5 dba:secret@22 ~/.ssh/db.pem jump@192.168.100.254"}},
+		{"prod", []string{
+			"192.168.100.1 admin:secret@22  ",
+			"192.168.100.2 admin:secret@22  ",
+			"192.168.100.3 root:p a#ss@2222  ",
+			"192.168.100.5 dba:secret@22 ~/.ssh/db.pem jump@192.168.100.254",
+		}},
+	}
+	for _, tt := range tests {
+		if got := inventoryHostStrings(t, inv, tt.group); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("hosts of %s = %q, want %q", tt.group, got, tt.want)
+		}
+	}
+	g, _ := inv.GetGroup("web")
+	if g.Hosts[0].Line != 5 || g.Hosts[2].Line != 6 {
+		t.Errorf("line numbers of web hosts = %d, %d, want 5, 6", g.Hosts[0].Line, g.Hosts[2].Line)
+	}
+
+	for _, bad := range []string{"[web:unknown]\n", "[web]\nweb[3:1]\n", "[web]\nweb01 ansible_port\n", "[web:vars]\nansible_user\n"} {
+		if _, err := ParseAnsibleINIInventory([]byte(bad), "hosts"); err == nil {
+			t.Errorf("ParseAnsibleINIInventory(%q) doesn't fail", bad)
+		}
+	}
+}
+
+func TestSplitAnsibleHostPort(t *testing.T) {
+	tests := []struct {
+		in, host, port string
+	}{
+		{"web01", "web01", ""},
+		{"web01:2222", "web01", "2222"},
+		{"192.168.100.1:2222", "192.168.100.1", "2222"},
+		{"web[01:10]", "web[01:10]", ""},
+		{"web[01:10:2]", "web[01:10:2]", ""},
+		{"web[01:10]:2222", "web[01:10]", "2222"},
+		{"fe80::1", "fe80::1", ""},
+		{"[2001:db8::1]:2222", "2001:db8::1", "2222"},
+		{"web01:ssh", "web01:ssh", ""},
+	}
+	for _, tt := range tests {
+		if host, port := splitAnsibleHostPort(tt.in); host != tt.host || port != tt.port {
+			t.Errorf("splitAnsibleHostPort(%q) = %q, %q, want %q, %q", tt.in, host, port, tt.host, tt.port)
+		}
+	}
+}
+
+func TestParseAnsibleINIInventoryHostPort(t *testing.T) {
+	buf := []byte(`[web]
+web01.example.com:2200
+web[02:03]:2201 ansible_user=admin
+web04:2202 ansible_port=2222
+`)
+	inv, err := ParseAnsibleINIInventory(buf, "hosts")
+	if err != nil {
+		t.Fatalf("ParseAnsibleINIInventory failed, %s", err)
+	}
+	want := []string{
+		"web01.example.com root:@2200  ",
+		"web02 admin:@2201  ",
+		"web03 admin:@2201  ",
+		"web04 root:@2222  ",
+	}
+	if got := inventoryHostStrings(t, inv, "web"); !reflect.DeepEqual(got, want) {
+		t.Errorf("hosts of web = %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		in      string
+		wantErr string
+	}{
+		{"[web]\nweb_01\n", "'web_01' is not a valid host name or IP address, please set ansible_host for it, in host group 'web' of hosts:2"},
+		{"[web]\nweb_01 ansible_host=192.168.100.1\n", ""},
+	}
+	for _, tt := range tests {
+		inv, err := ParseAnsibleINIInventory([]byte(tt.in), "hosts")
+		if err != nil {
+			t.Fatalf("ParseAnsibleINIInventory(%q) failed, %s", tt.in, err)
+		}
+		err = inv.HostLineError()
+		if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("HostLineError() of %q = %v, want %q", tt.in, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseAnsibleYAMLInventory(t *testing.T) {
+	buf := []byte(`all:
+  vars:
+    ansible_user: root
+    ansible_password: secret
+  hosts:
+    192.168.100.9:
+  children:
+    web:
+      hosts:
+        192.168.100.[1:2]:
+          ansible_port: 2222
+    db:
+      vars:
+        ansible_user: dba
+      hosts:
+        db01:
+          ansible_host: 192.168.100.5
+`)
+	inv, err := ParseAnsibleYAMLInventory(buf, "hosts.yml")
+	if err != nil {
+		t.Fatalf("ParseAnsibleYAMLInventory failed, %s", err)
+	}
+	tests := []struct {
+		group string
+		want  []string
+	}{
+		{"web", []string{"192.168.100.1 root:secret@2222  ", "192.168.100.2 root:secret@2222  "}},
+		{"db", []string{"192.168.100.5 dba:secret@22  "}},
+	}
+	for _, tt := range tests {
+		if got := inventoryHostStrings(t, inv, tt.group); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("hosts of %s = %q, want %q", tt.group, got, tt.want)
+		}
+	}
+	if _, err := ParseAnsibleYAMLInventory([]byte("all:\n  hosts:\n    web[3:1]:\n"), "hosts.yml"); err == nil {
+		t.Errorf("ParseAnsibleYAMLInventory doesn't fail with an invalid host range")
+	}
+}
+
+func TestParseAnsibleJSONInventory(t *testing.T) {
+	buf := []byte(`{
+  "web": {"hosts": ["192.168.100.1", "192.168.100.2"], "vars": {"ansible_user": "admin"}},
+  "db": ["192.168.100.5"],
+  "prod": {"children": ["web", "db"]},
+  "all": {"vars": {"ansible_password": "secret"}},
+  "_meta": {"hostvars": {"192.168.100.2": {"ansible_port": 2222}}}
+}`)
+	inv, err := ParseAnsibleJSONInventory(buf, "inventory.py")
+	if err != nil {
+		t.Fatalf("ParseAnsibleJSONInventory failed, %s", err)
+	}
+	want := []string{
+		"192.168.100.1 admin:secret@22  ",
+		"192.168.100.2 admin:secret@2222  ",
+		"192.168.100.5 root:secret@22  ",
+	}
+	if got := inventoryHostStrings(t, inv, "prod"); !reflect.DeepEqual(got, want) {
+		t.Errorf("hosts of prod = %q, want %q", got, want)
+	}
+	if _, err := ParseAnsibleJSONInventory([]byte("not json"), "inventory.py"); err == nil {
+		t.Errorf("ParseAnsibleJSONInventory doesn't fail with invalid json")
+	}
+}
+
+func TestIsAnsibleINIInventory(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"[web]\n192.168.100.1 ansible_user=admin\n", true},
+		{"[web:vars]\nansible_user=admin\n", true},
+		{"[web]\nhosts = 192.168.100.1\nuser = root\n", false},
+		{"[web]\nhosts = \"\"\"\n192.168.100.1 user=admin\n\"\"\"\n", false},
+	}
+	for _, tt := range tests {
+		if got := IsAnsibleINIInventory([]byte(tt.in)); got != tt.want {
+			t.Errorf("IsAnsibleINIInventory(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/pkg/sftp"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// set the mode and modification time of the transferred files the same as the source files
+var TransferPreserve bool
+
+// the mode of the transferred files, nil keeps the default mode(or the mode of the replaced file)
+var TransferMode *os.FileMode
+
+// the owner and group of the transferred files, the user and group names or numeric ids
+var (
+	TransferOwner string
+	TransferGroup string
+)
+
+// parse the owner and group like chown, e.g. "app", "app:app" or ":app"
+func ParseOwner(s string) (string, string) {
+	if i := strings.Index(s, ":"); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
+
+// parse the octal file mode like 0640 or 4755
+func ParseFileMode(s string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 07777 {
+		return 0, fmt.Errorf("ERROR: invalid file mode \"%s\", an octal number like 0640 is expected", s)
+	}
+	mode := os.FileMode(m & 0777)
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode, nil
+}
+
+// the permission bits of the file mode, including setuid, setgid and sticky bits
+func permMode(mode os.FileMode) os.FileMode {
+	return mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+}
+
+// e.g. "app:app", "app" or ":app"
+func ownerSpec() string {
+	if TransferGroup == "" {
+		return TransferOwner
+	}
+	return TransferOwner + ":" + TransferGroup
+}
+
+// the error of changing ownership without privilege
+func ownershipError(p, loginUser string) error {
+	return fmt.Errorf("change owner of %s to %s failed, permission denied, user %s can't change the ownership of files without root privilege, login as root to use --owner", p, ownerSpec(), loginUser)
+}
+
+// set the mode, ownership and modification time of the uploaded file by TransferMode, TransferPreserve,
+// TransferOwner and TransferGroup, the modification time is always set for the sync action
+func (t *sftpTransfer) setRemoteAttributes(remotePath string, localInfo os.FileInfo) error {
+	displayPath := targetPath(remotePath)
+	if TransferOwner != "" || TransferGroup != "" {
+		uid, gid, err := t.remoteOwnerIDs()
+		if err != nil {
+			return err
+		}
+		// sftp sets the owner and the group together, keep the current one if it's not specified
+		if uid < 0 || gid < 0 {
+			info, err := t.sftpClient.Stat(remotePath)
+			if err != nil {
+				return fmt.Errorf("stat %s failed, %s", displayPath, err)
+			}
+			if stat, ok := info.Sys().(*sftp.FileStat); ok {
+				if uid < 0 {
+					uid = int(stat.UID)
+				}
+				if gid < 0 {
+					gid = int(stat.GID)
+				}
+			}
+		}
+		if err := t.sftpClient.Chown(remotePath, uid, gid); err != nil {
+			if os.IsPermission(err) {
+				return ownershipError(displayPath, t.user)
+			}
+			return fmt.Errorf("change owner of %s to %s failed, %s", displayPath, ownerSpec(), err)
+		}
+	}
+	// changing the owner clears the setuid and setgid bits, set the mode after it
+	if TransferMode != nil || TransferPreserve {
+		mode := permMode(localInfo.Mode())
+		if TransferMode != nil {
+			mode = *TransferMode
+		}
+		if err := t.sftpClient.Chmod(remotePath, mode); err != nil {
+			return fmt.Errorf("set mode of %s failed, %s", displayPath, err)
+		}
+	}
+	if TransferPreserve || t.sync {
+		if err := t.sftpClient.Chtimes(remotePath, localInfo.ModTime(), localInfo.ModTime()); err != nil {
+			return fmt.Errorf("set modification time of %s failed, %s", displayPath, err)
+		}
+	}
+	return nil
+}
+
+// resolve TransferOwner and TransferGroup on the remote host once, -1 if it's not specified
+func (t *sftpTransfer) remoteOwnerIDs() (int, int, error) {
+	if t.ownerIDs == nil {
+		uid, gid := -1, -1
+		var err error
+		if TransferOwner != "" {
+			uid, err = t.remoteID(TransferOwner, "id -u -- ", "user")
+		}
+		if err == nil && TransferGroup != "" {
+			gid, err = t.remoteID(TransferGroup, "getent group -- ", "group")
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		t.ownerIDs = []int{uid, gid}
+	}
+	return t.ownerIDs[0], t.ownerIDs[1], nil
+}
+
+// the numeric id of the user or group name on the remote host, the numeric id is used as it is
+func (t *sftpTransfer) remoteID(name, command, kind string) (int, error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	out, err := t.runOutput(command + shellQuote(name))
+	// getent prints "name:x:gid:members"
+	fields := strings.Split(strings.TrimSpace(out), ":")
+	if kind == "group" && len(fields) > 2 {
+		fields = fields[2:3]
+	}
+	id, convErr := strconv.Atoi(fields[0])
+	if err != nil || convErr != nil {
+		return 0, fmt.Errorf("%s %s not found on host %s", kind, name, t.host)
+	}
+	return id, nil
+}
+
+// set the mode, ownership and modification time of the downloaded file like setRemoteAttributes
+func setLocalAttributes(localPath string, remoteInfo os.FileInfo) error {
+	if TransferOwner != "" || TransferGroup != "" {
+		uid, gid, err := localOwnerIDs()
+		if err != nil {
+			return err
+		}
+		if err := os.Chown(localPath, uid, gid); err != nil {
+			if os.IsPermission(err) {
+				loginUser := "current user"
+				if u, err := user.Current(); err == nil {
+					loginUser = u.Username
+				}
+				return ownershipError(localPath, loginUser)
+			}
+			return fmt.Errorf("change owner of %s to %s failed, %s", localPath, ownerSpec(), err)
+		}
+	}
+	if TransferMode != nil || TransferPreserve {
+		mode := permMode(remoteInfo.Mode())
+		if TransferMode != nil {
+			mode = *TransferMode
+		}
+		if err := os.Chmod(localPath, mode); err != nil {
+			return err
+		}
+	}
+	if TransferPreserve {
+		if err := os.Chtimes(localPath, remoteInfo.ModTime(), remoteInfo.ModTime()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve TransferOwner and TransferGroup on the local machine, -1 if it's not specified
+func localOwnerIDs() (int, int, error) {
+	uid, gid := -1, -1
+	if TransferOwner != "" {
+		id := TransferOwner
+		if _, err := strconv.Atoi(id); err != nil {
+			u, err := user.Lookup(TransferOwner)
+			if err != nil {
+				return 0, 0, fmt.Errorf("user %s not found on the local machine", TransferOwner)
+			}
+			id = u.Uid
+		}
+		uid, _ = strconv.Atoi(id)
+	}
+	if TransferGroup != "" {
+		id := TransferGroup
+		if _, err := strconv.Atoi(id); err != nil {
+			g, err := user.LookupGroup(TransferGroup)
+			if err != nil {
+				return 0, 0, fmt.Errorf("group %s not found on the local machine", TransferGroup)
+			}
+			id = g.Gid
+		}
+		gid, _ = strconv.Atoi(id)
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseFileMode(t *testing.T) {
+	tests := []struct {
+		in   string
+		want os.FileMode
+		err  bool
+	}{
+		{"0640", 0640, false},
+		{"640", 0640, false},
+		{"0755", 0755, false},
+		{"4755", 0755 | os.ModeSetuid, false},
+		{"2755", 0755 | os.ModeSetgid, false},
+		{"1777", 0777 | os.ModeSticky, false},
+		{"7777", 0777 | os.ModeSetuid | os.ModeSetgid | os.ModeSticky, false},
+		{"0", 0, false},
+		{"0888", 0, true},
+		{"17777", 0, true},
+		{"rw-r--r--", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFileMode(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseFileMode(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseFileMode(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	tests := []struct {
+		in, owner, group string
+	}{
+		{"app", "app", ""},
+		{"app:app", "app", "app"},
+		{"app:web", "app", "web"},
+		{":web", "", "web"},
+		{"app:", "app", ""},
+		{"1000:1000", "1000", "1000"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		owner, group := ParseOwner(tt.in)
+		if owner != tt.owner || group != tt.group {
+			t.Errorf("ParseOwner(%q) = %q, %q, want %q, %q", tt.in, owner, group, tt.owner, tt.group)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// uploaded files are written to the hidden temporary file ".<name>.<random>.ssgo-tmp" in the same directory first
+const tempFileSuffix = ".ssgo-tmp"
+
+// keep the replaced remote files as "<name>.<timestamp>.bak", see TransferBackup
+var TransferBackup bool
+
+// the timestamp of the backups, the same for all files and hosts of one run
+var backupTimestamp = time.Now().Format("20060102-150405")
+
+const backupFileSuffix = ".bak"
+
+// the hidden temporary file of the remote file, the random part keeps the uploads of the same file apart
+func tempPath(remotePath string) string {
+	random := make([]byte, 4)
+	rand.Read(random)
+	return path.Join(path.Dir(remotePath), fmt.Sprintf(".%s.%x%s", path.Base(remotePath), random, tempFileSuffix))
+}
+
+// the remote file of the temporary or partial file
+func targetPath(p string) string {
+	if base := path.Base(p); strings.HasPrefix(base, ".") && strings.HasSuffix(base, tempFileSuffix) {
+		base = strings.TrimSuffix(strings.TrimPrefix(base, "."), tempFileSuffix)
+		return path.Join(path.Dir(p), base[:strings.LastIndex(base, ".")])
+	}
+	return strings.TrimSuffix(p, PartFileSuffix)
+}
+
+// whether the remote file is the backup, partial or temporary file made by ssgo
+func isTransferFile(p string) bool {
+	base := path.Base(p)
+	if strings.HasSuffix(base, PartFileSuffix) || strings.HasPrefix(base, ".") && strings.HasSuffix(base, tempFileSuffix) {
+		return true
+	}
+	if !strings.HasSuffix(base, backupFileSuffix) {
+		return false
+	}
+	// "<name>.<timestamp>.bak"
+	name := strings.TrimSuffix(base, backupFileSuffix)
+	i := strings.LastIndex(name, ".")
+	if i < 0 {
+		return false
+	}
+	_, err := time.Parse("20060102-150405", name[i+1:])
+	return err == nil
+}
+
+// flush the remote file to stable storage if the server supports fsync@openssh.com
+func syncFile(f *sftp.File) error {
+	err := f.Sync()
+	var statusErr *sftp.StatusError
+	if errors.As(err, &statusErr) && statusErr.FxCode() == sftp.ErrSSHFxOpUnsupported {
+		return nil
+	}
+	return err
+}
+
+// the limit of the symlinks followed by resolveLink, the same as the Linux kernel
+const maxSymlinks = 40
+
+// the file the remote symlink points to, the temporary file and the rename have to be in its directory, otherwise
+// the symlink itself is replaced by a regular file, other paths are returned as they are
+func (t *sftpTransfer) resolveLink(remotePath string) (string, error) {
+	resolved := remotePath
+	for i := 0; i < maxSymlinks; i++ {
+		info, err := t.sftpClient.Lstat(resolved)
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			return resolved, nil
+		}
+		link, err := t.sftpClient.ReadLink(resolved)
+		if err != nil {
+			return "", fmt.Errorf("read remote symlink %s failed, %s", resolved, err)
+		}
+		if !path.IsAbs(link) {
+			link = path.Join(path.Dir(resolved), link)
+		}
+		resolved = link
+	}
+	return "", fmt.Errorf("resolve remote symlink %s failed, too many levels of symbolic links", remotePath)
+}
+
+// replace the remote file with the complete temporary file, the mode and ownership of the replaced file are kept
+// unless they are set by setRemoteAttributes, and it's kept as the backup by TransferBackup
+func (t *sftpTransfer) replace(tmpPath, remotePath string, localInfo os.FileInfo) error {
+	info, err := t.sftpClient.Lstat(remotePath)
+	replaced := err == nil && info.Mode().IsRegular()
+	if replaced {
+		// changing the owner usually needs root, the temporary file is owned by the login user then
+		if stat, ok := info.Sys().(*sftp.FileStat); ok {
+			t.sftpClient.Chown(tmpPath, int(stat.UID), int(stat.GID))
+		}
+		if err := t.sftpClient.Chmod(tmpPath, permMode(info.Mode())); err != nil {
+			return fmt.Errorf("set mode of %s failed, %s", targetPath(tmpPath), err)
+		}
+	}
+	if err := t.setRemoteAttributes(tmpPath, localInfo); err != nil {
+		return err
+	}
+	// the backup may move the remote file away, so it's the last step before rename
+	if replaced && TransferBackup {
+		if err := t.backup(remotePath); err != nil {
+			return err
+		}
+	}
+	return t.rename(tmpPath, remotePath)
+}
+
+// keep the remote file as "<name>.<timestamp>.bak" by a hard link, the remote file is still in place until it's
+// replaced by rename
+func (t *sftpTransfer) backup(remotePath string) error {
+	backupPath := fmt.Sprintf("%s.%s%s", remotePath, backupTimestamp, backupFileSuffix)
+	t.sftpClient.Remove(backupPath)
+	if err := t.sftpClient.Link(remotePath, backupPath); err == nil {
+		return nil
+	}
+	// the server doesn't support hardlink@openssh.com
+	if err := t.sftpClient.Rename(remotePath, backupPath); err != nil {
+		return fmt.Errorf("backup %s failed, %s", remotePath, err)
+	}
+	return nil
+}
+
+// the latest backup of the remote file, "" if there is none
+func (t *sftpTransfer) latestBackup(remotePath string) (string, error) {
+	entries, err := t.sftpClient.ReadDir(path.Dir(remotePath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("read remote directory %s failed, %s", path.Dir(remotePath), err)
+	}
+	prefix := path.Base(remotePath) + "."
+	var backups []string
+	for _, e := range entries {
+		timestamp := strings.TrimSuffix(strings.TrimPrefix(e.Name(), prefix), backupFileSuffix)
+		if !e.Mode().IsRegular() || !strings.HasPrefix(e.Name(), prefix) || !strings.HasSuffix(e.Name(), backupFileSuffix) {
+			continue
+		}
+		if _, err := time.Parse("20060102-150405", timestamp); err == nil {
+			backups = append(backups, e.Name())
+		}
+	}
+	if len(backups) == 0 {
+		return "", nil
+	}
+	sort.Strings(backups)
+	return path.Join(path.Dir(remotePath), backups[len(backups)-1]), nil
+}
+
+// restore the remote file from its latest backup, the backup is renamed over the remote file,
+// files without any backup are skipped
+func (t *sftpTransfer) restoreBackup(remotePath string) error {
+	remotePath, err := t.resolveLink(remotePath)
+	if err != nil {
+		return err
+	}
+	backupPath, err := t.latestBackup(remotePath)
+	if err != nil {
+		return err
+	}
+	if backupPath == "" {
+		t.skippedFiles++
+		return nil
+	}
+	if err := t.rename(backupPath, remotePath); err != nil {
+		return fmt.Errorf("restore %s from %s failed, %s", remotePath, backupPath, err)
+	}
+	t.files++
+	return nil
+}
@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"path"
+	"regexp"
+	"testing"
+)
+
+func TestTempPath(t *testing.T) {
+	tempName := regexp.MustCompile(`^\.app\.conf\.[0-9a-f]{8}\.ssgo-tmp$`)
+	p := tempPath("/etc/app/app.conf")
+	if path.Dir(p) != "/etc/app" || !tempName.MatchString(path.Base(p)) {
+		t.Errorf("tempPath(/etc/app/app.conf) = %s, want /etc/app/.app.conf.<random>.ssgo-tmp", p)
+	}
+	if p == tempPath("/etc/app/app.conf") {
+		t.Errorf("tempPath returns the same path %s twice", p)
+	}
+}
+
+func TestTargetPath(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{tempPath("/etc/app/app.conf"), "/etc/app/app.conf"},
+		{tempPath("/etc/app/.hidden"), "/etc/app/.hidden"},
+		{tempPath("/etc/app/noext"), "/etc/app/noext"},
+		{tempPath("relative/a.tar.gz"), "relative/a.tar.gz"},
+		{"/etc/app/app.conf" + PartFileSuffix, "/etc/app/app.conf"},
+		{"/etc/app/app.conf", "/etc/app/app.conf"},
+	}
+	for _, tt := range tests {
+		if got := targetPath(tt.in); got != tt.want {
+			t.Errorf("targetPath(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIsTransferFile(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{tempPath("/data/a.txt"), true},
+		{"/data/a.txt" + PartFileSuffix, true},
+		{"/data/a.txt.20261019-183321.bak", true},
+		{"/data/a.txt", false},
+		{"/data/a.bak", false},
+		{"/data/a.txt.2026.bak", false},
+		{"/data/a.ssgo-tmp", false},
+	}
+	for _, tt := range tests {
+		if got := isTransferFile(tt.in); got != tt.want {
+			t.Errorf("isTransferFile(%s) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -1,34 +1,148 @@
 package utils
 
-import "sync"
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
 
-// https://www.golangtc.com/t/559e97d6b09ecc22f6000053
-// thank you very much
+// a bounded worker pool, a fixed number of workers run the jobs from the queue in order,
+// so no goroutine is parked for the jobs waiting in the queue
+type WorkerPool struct {
+	ctx     context.Context
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    []func(ctx context.Context)
+	closed  bool
+	pending sync.WaitGroup
+	workers sync.WaitGroup
+	queued  int
+	running int
+	done    int
+}
 
-type Pool struct {
-	queue chan int
-	Wg    *sync.WaitGroup
-	Size  int // pool size
+// progress of the worker pool, jobs waiting in the queue, running by the workers and done
+type PoolProgress struct {
+	Queued  int
+	Running int
+	Done    int
 }
 
-func NewPool(cap, total int) *Pool {
-	if cap < 1 {
-		cap = 1
+// start size workers, the jobs are run with ctx and should return quickly once ctx is done
+func NewWorkerPool(ctx context.Context, size int) *WorkerPool {
+	if size < 1 {
+		size = 1
 	}
-	p := &Pool{
-		queue: make(chan int, cap),
-		Wg:    new(sync.WaitGroup),
+	p := &WorkerPool{ctx: ctx}
+	p.cond = sync.NewCond(&p.mu)
+	p.workers.Add(size)
+	for i := 0; i < size; i++ {
+		go p.work()
 	}
-	p.Wg.Add(total)
-	p.Size = 0
 	return p
 }
-func (p *Pool) AddOne() {
-	p.queue <- 1
-	p.Size++
+
+// add the job to the queue, it never blocks and must not be called after Close
+func (p *WorkerPool) Submit(job func(ctx context.Context)) {
+	p.pending.Add(1)
+	p.mu.Lock()
+	p.jobs = append(p.jobs, job)
+	p.queued++
+	p.mu.Unlock()
+	p.cond.Signal()
+}
+
+func (p *WorkerPool) work() {
+	defer p.workers.Done()
+	for {
+		p.mu.Lock()
+		for len(p.jobs) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if len(p.jobs) == 0 {
+			p.mu.Unlock()
+			return
+		}
+		job := p.jobs[0]
+		p.jobs[0] = nil
+		p.jobs = p.jobs[1:]
+		p.queued--
+		p.running++
+		p.mu.Unlock()
+
+		job(p.ctx)
+
+		p.mu.Lock()
+		p.running--
+		p.done++
+		p.mu.Unlock()
+		p.pending.Done()
+	}
+}
+
+// wait until all submitted jobs are done
+func (p *WorkerPool) Wait() {
+	p.pending.Wait()
 }
-func (p *Pool) DelOne() {
-	<-p.queue
-	p.Wg.Done()
-	p.Size--
+
+// stop the workers after the jobs in the queue are done
+func (p *WorkerPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.cond.Broadcast()
+	p.workers.Wait()
+}
+
+func (p *WorkerPool) Progress() PoolProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return PoolProgress{Queued: p.queued, Running: p.running, Done: p.done}
+}
+
+// get the batch sizes of rolling execution by --serial, e.g. "5", "10%" or "1,5,25%",
+// the last one is repeated until all hosts are done, all hosts in one batch if serial is empty
+func GetSerialBatches(serial string, total int) ([]int, error) {
+	var sizes, batches []int
+	for _, item := range strings.Split(serial, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var size int
+		if strings.HasSuffix(item, "%") {
+			percent, err := strconv.ParseFloat(strings.TrimSuffix(item, "%"), 64)
+			if err != nil || percent <= 0 || percent > 100 {
+				return batches, fmt.Errorf("ERROR: invalid serial '%s', the percentage should be between 0%% and 100%%", item)
+			}
+			size = int(float64(total) * percent / 100)
+		} else {
+			n, err := strconv.Atoi(item)
+			if err != nil || n < 1 {
+				return batches, fmt.Errorf("ERROR: invalid serial '%s', e.g. 5, 10%% or 1,5,25%%", item)
+			}
+			size = n
+		}
+		if size < 1 {
+			size = 1
+		}
+		sizes = append(sizes, size)
+	}
+	if len(sizes) == 0 {
+		sizes = append(sizes, total)
+	}
+	for i, remain := 0, total; remain > 0; i++ {
+		size := sizes[len(sizes)-1]
+		if i < len(sizes) {
+			size = sizes[i]
+		}
+		if size > remain {
+			size = remain
+		}
+		batches = append(batches, size)
+		remain -= size
+	}
+	return batches, nil
 }
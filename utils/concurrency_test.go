@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetSerialBatches(t *testing.T) {
+	tests := []struct {
+		serial string
+		total  int
+		want   []int
+		err    bool
+	}{
+		{"", 10, []int{10}, false},
+		{"5", 10, []int{5, 5}, false},
+		{"3", 10, []int{3, 3, 3, 1}, false},
+		{"20", 10, []int{10}, false},
+		{"30%", 10, []int{3, 3, 3, 1}, false},
+		{"10%", 5, []int{1, 1, 1, 1, 1}, false},
+		{"100%", 7, []int{7}, false},
+		{"1,5,25%", 20, []int{1, 5, 5, 5, 4}, false},
+		{"1, 2", 6, []int{1, 2, 2, 1}, false},
+		{"5", 0, nil, false},
+		{"0", 10, nil, true},
+		{"-1", 10, nil, true},
+		{"abc", 10, nil, true},
+		{"0%", 10, nil, true},
+		{"101%", 10, nil, true},
+		{"1,x%", 10, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := GetSerialBatches(tt.serial, tt.total)
+		if (err != nil) != tt.err {
+			t.Errorf("GetSerialBatches(%q, %d) error = %v, want error %v", tt.serial, tt.total, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GetSerialBatches(%q, %d) = %v, want %v", tt.serial, tt.total, got, tt.want)
+		}
+	}
+}
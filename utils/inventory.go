@@ -0,0 +1,517 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// section names which can't be used as host group name
+const (
+	inventoryAllGroup     = "all"
+	inventoryVarsSection  = "vars"
+	inventoryDefaultGroup = "DEFAULT"
+)
+
+// login variables which can be inherited from DEFAULT/[vars] section, parent groups or set per host
+var InventoryHostVarKeys = []string{"user", "pass", "port", "key", "jump"}
+
+// a remote host with its login information
+type Host struct {
+	Address  string
+	User     string
+	Password string
+	Port     int
+	Key      string
+	Jump     string
+	Group    string
+	// where the host comes from, e.g. "config.ini:12 [web]" or "hosts.txt:3", used to report duplicate hosts
+	Source string
+}
+
+// a line of hosts block, the host expression with its own login variables
+// Line is the line number in the inventory file, 0 if unknown
+// Err is the error of parsing the line, all lines are parsed so that validate reports every error
+type InventoryHostEntry struct {
+	Expr string
+	Vars map[string]string
+	Line int
+	Err  error
+}
+
+// a host group of the inventory file
+type InventoryGroup struct {
+	Name     string
+	Vars     map[string]string
+	Hosts    []InventoryHostEntry
+	Children []string
+}
+
+// hosts resolved from one host group
+type HostGroup struct {
+	Name  string
+	Hosts []Host
+}
+
+type Inventory struct {
+	Path   string
+	Vars   map[string]string
+	Groups []*InventoryGroup
+}
+
+// load the inventory file, parse host groups, children and inheritable login variables
+// format should be one of InventorySourceFormats, detected by DetectInventoryFormat if empty
+func LoadInventory(inventoryFilePath, format string) (*Inventory, error) {
+	inv, err := ParseInventory(inventoryFilePath, format)
+	if err != nil {
+		return nil, err
+	}
+	if err := inv.HostLineError(); err != nil {
+		return nil, err
+	}
+	// decrypt vault encrypted login variables, e.g. pass = $SSGO_VAULT;1.0;AES256-GCM;...
+	if err := decryptInventoryVars(inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// parse the inventory file like LoadInventory, but the vault encrypted login variables are kept encrypted,
+// and the errors of host lines are kept in the host entries, see HostLineError
+func ParseInventory(inventoryFilePath, format string) (*Inventory, error) {
+	if format == "" {
+		format = DetectInventoryFormat(inventoryFilePath)
+	}
+	var inv *Inventory
+	var err error
+	switch format {
+	case "ini":
+		inv, err = loadINIInventory(inventoryFilePath)
+	case "yaml", "json":
+		inv, err = loadDocumentInventory(inventoryFilePath, format)
+	case "ansible-ini", "ansible-yaml", "ansible-json":
+		inv, err = loadAnsibleInventory(inventoryFilePath, format)
+	case "script", "http":
+		inv, err = loadDynamicInventory(inventoryFilePath, format)
+	default:
+		return nil, fmt.Errorf("ERROR: unsupported inventory format '%s', only %s is supported", format, strings.Join(InventorySourceFormats, ","))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func loadINIInventory(inventoryFilePath string) (*Inventory, error) {
+	cfg, err := Cfg(inventoryFilePath)
+	if err != nil {
+		return nil, err
+	}
+	inv := &Inventory{Path: inventoryFilePath, Vars: map[string]string{}}
+	hostsLines := map[string][]int{}
+	if buf, err := ReadInventoryFile(inventoryFilePath); err == nil {
+		hostsLines = iniHostsLineNumbers(buf)
+	}
+	// [vars] section overrides the DEFAULT section
+	for _, name := range []string{inventoryDefaultGroup, inventoryVarsSection} {
+		s, err := cfg.GetSection(name)
+		if err != nil {
+			continue
+		}
+		for _, k := range InventoryHostVarKeys {
+			if s.HasKey(k) {
+				inv.Vars[k] = s.Key(k).String()
+			}
+		}
+	}
+	for _, s := range cfg.Sections() {
+		if s.Name() == inventoryDefaultGroup || s.Name() == inventoryVarsSection {
+			continue
+		}
+		if s.Name() == inventoryAllGroup {
+			return nil, fmt.Errorf("ERROR: '%s' can't be used as a host group name in %s", inventoryAllGroup, inventoryFilePath)
+		}
+		g := &InventoryGroup{Name: s.Name(), Vars: map[string]string{}}
+		for _, k := range InventoryHostVarKeys {
+			if s.HasKey(k) {
+				g.Vars[k] = s.Key(k).String()
+			}
+		}
+		if s.HasKey("hosts") {
+			entries := ParseHostsBlock(s.Key("hosts").String())
+			if lines := hostsLines[s.Name()]; len(lines) == len(entries) {
+				for i := range entries {
+					entries[i].Line = lines[i]
+				}
+			}
+			g.Hosts = entries
+		}
+		if s.HasKey("children") {
+			for _, c := range strings.Split(s.Key("children").String(), ",") {
+				c = strings.TrimSpace(c)
+				if c != "" {
+					g.Children = append(g.Children, c)
+				}
+			}
+		}
+		inv.Groups = append(inv.Groups, g)
+	}
+	return inv, nil
+}
+
+func (inv *Inventory) GetGroup(name string) (*InventoryGroup, error) {
+	for _, g := range inv.Groups {
+		if g.Name == name {
+			return g, nil
+		}
+	}
+	return nil, fmt.Errorf("ERROR: host group '%s' not found in %s", name, inv.Path)
+}
+
+func (inv *Inventory) GroupNames() []string {
+	var names []string
+	for _, g := range inv.Groups {
+		names = append(names, g.Name)
+	}
+	return names
+}
+
+// the first group (in file order) which lists the given group as its child, a group listed as the child of
+// several groups inherits the login variables of the first one only
+func (inv *Inventory) parentGroup(name string) *InventoryGroup {
+	for _, g := range inv.Groups {
+		for _, c := range g.Children {
+			if c == name {
+				return g
+			}
+		}
+	}
+	return nil
+}
+
+// get the login variables of a group, inherited from DEFAULT -> [vars] -> parent groups -> group itself,
+// the parent is the first group in file order which lists it as a child, see parentGroup
+func (inv *Inventory) GetGroupVars(name string) (map[string]string, error) {
+	var chain []*InventoryGroup
+	visited := map[string]bool{}
+	g, err := inv.GetGroup(name)
+	if err != nil {
+		return nil, err
+	}
+	for ; g != nil; g = inv.parentGroup(g.Name) {
+		if visited[g.Name] {
+			return nil, fmt.Errorf("ERROR: host group '%s' has a circular children relationship", g.Name)
+		}
+		visited[g.Name] = true
+		chain = append(chain, g)
+	}
+	vars := map[string]string{}
+	for k, v := range inv.Vars {
+		vars[k] = v
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		for k, v := range chain[i].Vars {
+			vars[k] = v
+		}
+	}
+	return vars, nil
+}
+
+// get the hosts of a group, with children groups' hosts if withChildren is true
+func (inv *Inventory) GetGroupHosts(name string, withChildren bool) ([]Host, error) {
+	return inv.getGroupHosts(name, withChildren, map[string]bool{})
+}
+
+func (inv *Inventory) getGroupHosts(name string, withChildren bool, visited map[string]bool) ([]Host, error) {
+	var hosts []Host
+	if visited[name] {
+		return hosts, fmt.Errorf("ERROR: host group '%s' has a circular children relationship", name)
+	}
+	visited[name] = true
+	defer delete(visited, name)
+	g, err := inv.GetGroup(name)
+	if err != nil {
+		return hosts, err
+	}
+	vars, err := inv.GetGroupVars(name)
+	if err != nil {
+		return hosts, err
+	}
+	for _, e := range g.Hosts {
+		entryHosts, err := GetAvailableHostsFromEntries([]InventoryHostEntry{e}, vars, g.Name)
+		if err != nil {
+			if e.Line > 0 {
+				err = fmt.Errorf("%s, at %s", err, inventoryLocation(inv.Path, e.Line, ""))
+			}
+			return hosts, err
+		}
+		for i := range entryHosts {
+			entryHosts[i].Source = inventoryLocation(inv.Path, e.Line, g.Name)
+		}
+		hosts = append(hosts, entryHosts...)
+	}
+	if withChildren {
+		for _, c := range g.Children {
+			childHosts, err := inv.getGroupHosts(c, true, visited)
+			if err != nil {
+				return hosts, err
+			}
+			hosts = append(hosts, childHosts...)
+		}
+	}
+	return hosts, nil
+}
+
+// get the host groups to operate, "all" means every group which has its own hosts
+func (inv *Inventory) GetHostGroups(name string) ([]HostGroup, error) {
+	var groups []HostGroup
+	if name != inventoryAllGroup {
+		hosts, err := inv.GetGroupHosts(name, true)
+		if err != nil {
+			return groups, err
+		}
+		groups = append(groups, HostGroup{Name: name, Hosts: hosts})
+		return groups, nil
+	}
+	for _, g := range inv.Groups {
+		if len(g.Hosts) == 0 {
+			continue
+		}
+		hosts, err := inv.GetGroupHosts(g.Name, false)
+		if err != nil {
+			return groups, err
+		}
+		groups = append(groups, HostGroup{Name: g.Name, Hosts: hosts})
+	}
+	if len(groups) == 0 {
+		return groups, fmt.Errorf("ERROR: no host group with hosts found in %s", inv.Path)
+	}
+	return groups, nil
+}
+
+// parse a line of hosts block, e.g. "192.168.100.5,192.168.100.6-8 port=2222 user=admin"
+// returns the host expression and the per-host login variables
+func ParseHostLine(line string) (string, map[string]string, error) {
+	var exprs []string
+	vars := map[string]string{}
+	for _, field := range strings.Fields(line) {
+		if strings.HasPrefix(field, "#") {
+			// the rest of line is comment
+			break
+		}
+		if !strings.Contains(field, "=") {
+			exprs = append(exprs, strings.Trim(field, ","))
+			continue
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if !isInventoryHostVarKey(kv[0]) {
+			return "", vars, fmt.Errorf("ERROR: unknown host variable '%s' in '%s', valid variables are %s", kv[0], line, strings.Join(InventoryHostVarKeys, ","))
+		}
+		vars[kv[0]] = kv[1]
+	}
+	return strings.Join(exprs, ","), vars, nil
+}
+
+// get line numbers of the hosts block lines of each section, in the same order as ParseHostsBlock
+func iniHostsLineNumbers(buf []byte) map[string][]int {
+	hostsLines := map[string][]int{}
+	section, inHostsBlock := "", false
+	for i, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if inHostsBlock {
+			if strings.HasPrefix(line, `"""`) {
+				inHostsBlock = false
+			} else if line != "" && !strings.HasPrefix(line, "#") {
+				hostsLines[section] = append(hostsLines[section], i+1)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line[1 : len(line)-1]
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) != "hosts" {
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+		if strings.HasPrefix(value, `"""`) {
+			value = strings.TrimPrefix(value, `"""`)
+			if strings.HasSuffix(value, `"""`) {
+				value = strings.TrimSuffix(value, `"""`)
+			} else {
+				inHostsBlock = true
+			}
+		}
+		if value = strings.TrimSpace(value); value != "" && !strings.HasPrefix(value, "#") {
+			hostsLines[section] = append(hostsLines[section], i+1)
+		}
+	}
+	return hostsLines
+}
+
+// parse multi lines hosts block, lines with "#" prefix will be ignored, the error of each line is kept in its entry
+func ParseHostsBlock(multiLines string) []InventoryHostEntry {
+	var entries []InventoryHostEntry
+	for _, line := range strings.Split(strings.TrimSpace(multiLines), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		expr, vars, err := ParseHostLine(line)
+		entries = append(entries, InventoryHostEntry{Expr: expr, Vars: vars, Err: err})
+	}
+	return entries
+}
+
+// the error of the first host line failed to parse, nil if all host lines are parsed
+func (inv *Inventory) HostLineError() error {
+	for _, g := range inv.Groups {
+		for _, e := range g.Hosts {
+			if e.Err != nil {
+				return fmt.Errorf("%s, in host group '%s' of %s", e.Err, g.Name, inventoryLocation(inv.Path, e.Line, ""))
+			}
+		}
+	}
+	return nil
+}
+
+// format the entry as a line of hosts block, e.g. "192.168.100.5 port=2222 user=admin"
+func (e InventoryHostEntry) String() string {
+	fields := []string{e.Expr}
+	for _, k := range InventoryHostVarKeys {
+		if v, ok := e.Vars[k]; ok {
+			fields = append(fields, k+"="+v)
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// get available hosts from hosts entries, per-host login variables override the group's,
+// the entry which is neither IP Addresses nor host names is an error
+func GetAvailableHostsFromEntries(entries []InventoryHostEntry, groupVars map[string]string, groupName string) ([]Host, error) {
+	var availableHosts []Host
+	for _, e := range entries {
+		var ips []string
+		for _, expr := range strings.Split(e.Expr, ",") {
+			expr = strings.TrimSpace(expr)
+			if expr == "" {
+				continue
+			}
+			exprIPs, err := GetAvailableIPList(expr)
+			if err != nil {
+				if !CheckHostName(expr) {
+					return availableHosts, fmt.Errorf("ERROR: '%s' is neither a valid IP Address nor a host name in host group '%s'", expr, groupName)
+				}
+				// host name like web01.example.com
+				exprIPs = []string{expr}
+			}
+			ips = append(ips, exprIPs...)
+		}
+		vars := map[string]string{}
+		for k, v := range groupVars {
+			vars[k] = v
+		}
+		for k, v := range e.Vars {
+			vars[k] = v
+		}
+		for _, ip := range ips {
+			h, err := NewHostFromVars(ip, vars, groupName)
+			if err != nil {
+				return availableHosts, err
+			}
+			availableHosts = append(availableHosts, h)
+		}
+	}
+	if len(availableHosts) == 0 {
+		return availableHosts, fmt.Errorf("ERROR: no valid IP Address found in host group '%s', please check your input", groupName)
+	}
+	return availableHosts, nil
+}
+
+// create a host with login variables, user is 'root' and port is 22 by default
+func NewHostFromVars(address string, vars map[string]string, groupName string) (Host, error) {
+	h := Host{Address: address, User: "root", Port: 22, Group: groupName}
+	if v, ok := vars["user"]; ok && v != "" {
+		h.User = v
+	}
+	if v, ok := vars["port"]; ok && v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil || port < 1 || port > 65535 {
+			return h, fmt.Errorf("ERROR: '%s' is not a valid port for host %s in host group '%s'", v, address, groupName)
+		}
+		h.Port = port
+	}
+	h.Password = vars["pass"]
+	h.Key = vars["key"]
+	h.Jump = vars["jump"]
+	return h, nil
+}
+
+// location in the inventory file, e.g. "config.ini:12 [web]"
+func inventoryLocation(inventoryFilePath string, line int, groupName string) string {
+	location := inventoryFilePath
+	if line > 0 {
+		location = fmt.Sprintf("%s:%d", location, line)
+	}
+	if groupName != "" {
+		location = fmt.Sprintf("%s [%s]", location, groupName)
+	}
+	return location
+}
+
+// set where the hosts come from, sources[i] is the source of hosts[i]
+func SetHostSources(hosts []Host, sources []string) []Host {
+	for i := range hosts {
+		if i < len(sources) {
+			hosts[i].Source = sources[i]
+		}
+	}
+	return hosts
+}
+
+// create hosts with the same login information, e.g. hosts from --host-list or --host-file
+func NewHosts(ips []string, user, password, key string, port int, groupName string) []Host {
+	var hosts []Host
+	for _, ip := range ips {
+		hosts = append(hosts, Host{Address: ip, User: user, Password: password, Port: port, Key: key, Group: groupName})
+	}
+	return hosts
+}
+
+func GetHostAddresses(hosts []Host) []string {
+	var ips []string
+	for _, h := range hosts {
+		ips = append(ips, h.Address)
+	}
+	return ips
+}
+
+// check duplicate hosts by their IP Address and sort them, just like DuplicateIPAddressCheck
+func DuplicateHostCheck(hosts []Host) ([]Host, error) {
+	var retHosts, todoHosts []Host
+	var sources []string
+	for _, h := range hosts {
+		sources = append(sources, h.Source)
+	}
+	indexes, err := DuplicateCheck(GetHostAddresses(hosts), sources)
+	if err != nil {
+		return retHosts, err
+	}
+	for _, i := range indexes {
+		todoHosts = append(todoHosts, hosts[i])
+	}
+	for _, i := range SortIndexes(GetHostAddresses(todoHosts), HostSort) {
+		retHosts = append(retHosts, todoHosts[i])
+	}
+	return retHosts, nil
+}
+
+func isInventoryHostVarKey(key string) bool {
+	for _, k := range InventoryHostVarKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
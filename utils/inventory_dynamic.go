@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cache time of dynamic inventory result, 0 means do not cache
+var DynamicInventoryCacheTTL = 5 * time.Minute
+
+// timeout of running the inventory script or requesting the inventory url
+var DynamicInventoryTimeout = 60 * time.Second
+
+func IsInventoryURL(inventoryPath string) bool {
+	return strings.HasPrefix(inventoryPath, "http://") || strings.HasPrefix(inventoryPath, "https://")
+}
+
+// check if the inventory file is an executable script
+func IsInventoryScript(inventoryPath string) bool {
+	fi, err := os.Stat(inventoryPath)
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	return fi.Mode().Perm()&0111 != 0
+}
+
+// load dynamic inventory from an executable script(run with --list) or a http(s) url,
+// both should return ansible compatible json, the result is cached after it's parsed
+func loadDynamicInventory(source, format string) (*Inventory, error) {
+	cacheFile, cacheErr := dynamicInventoryCacheFile(source)
+	if cacheErr == nil && DynamicInventoryCacheTTL > 0 {
+		if fi, err := os.Stat(cacheFile); err == nil && time.Since(fi.ModTime()) < DynamicInventoryCacheTTL {
+			if buf, err := ioutil.ReadFile(cacheFile); err == nil {
+				if inv, err := ParseAnsibleJSONInventory(buf, source); err == nil {
+					return inv, nil
+				}
+			}
+		}
+	}
+	buf, err := fetchDynamicInventory(source, format)
+	if err != nil {
+		return nil, err
+	}
+	inv, err := ParseAnsibleJSONInventory(buf, source)
+	if err != nil {
+		return nil, err
+	}
+	if cacheErr == nil && DynamicInventoryCacheTTL > 0 {
+		writeDynamicInventoryCache(cacheFile, buf)
+	}
+	return inv, nil
+}
+
+func fetchDynamicInventory(source, format string) ([]byte, error) {
+	if format == "http" {
+		return requestInventoryURL(source)
+	}
+	return runInventoryScript(source)
+}
+
+// the result may contain login passwords, it's only readable by the current user
+func writeDynamicInventoryCache(cacheFile string, buf []byte) {
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0700); err != nil {
+		return
+	}
+	// the temporary file is created with mode 0600, and renamed over the cache file
+	f, err := ioutil.TempFile(filepath.Dir(cacheFile), filepath.Base(cacheFile)+".*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(buf)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), cacheFile)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// dynamic inventory result is cached in ~/.cache/ssgo
+func dynamicInventoryCacheFile(source string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	if !IsInventoryURL(source) {
+		if abs, err := filepath.Abs(source); err == nil {
+			source = abs
+		}
+	}
+	return filepath.Join(cacheDir, "ssgo", fmt.Sprintf("inventory-%x.json", sha256.Sum256([]byte(source)))), nil
+}
+
+func runInventoryScript(scriptPath string) ([]byte, error) {
+	var outBuffer, errBuffer bytes.Buffer
+	realPath, err := GetRealPath(scriptPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s not exist! please check", scriptPath)
+	}
+	cmd := exec.Command(realPath, "--list")
+	cmd.Stdout = &outBuffer
+	cmd.Stderr = &errBuffer
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("run inventory script %s failed, error message: %s", scriptPath, err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(DynamicInventoryTimeout):
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("run inventory script %s timeout after %s", scriptPath, DynamicInventoryTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("run inventory script %s failed, error message: %s %s", scriptPath, err, strings.TrimSpace(errBuffer.String()))
+	}
+	return outBuffer.Bytes(), nil
+}
+
+func requestInventoryURL(url string) ([]byte, error) {
+	client := &http.Client{Timeout: DynamicInventoryTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("request inventory url %s failed, error message: %s", url, err)
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("request inventory url %s failed, error message: %s", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("request inventory url %s failed, status: %s", url, resp.Status)
+	}
+	return buf, nil
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const dynamicInventoryJSON = `{
+  "web": {"hosts": ["192.168.100.1", "192.168.100.2"], "vars": {"ansible_user": "admin"}},
+  "_meta": {"hostvars": {"192.168.100.2": {"ansible_port": 2222}}}
+}`
+
+// the hosts of the group like "address user:pass@port key jump", with the hosts of its children
+func inventoryHostStrings(t *testing.T, inv *Inventory, group string) []string {
+	hosts, err := inv.GetGroupHosts(group, true)
+	if err != nil {
+		t.Fatalf("GetGroupHosts(%s) failed, %s", group, err)
+	}
+	var ret []string
+	for _, h := range hosts {
+		ret = append(ret, fmt.Sprintf("%s %s:%s@%d %s %s", h.Address, h.User, h.Password, h.Port, h.Key, h.Jump))
+	}
+	return ret
+}
+
+// a temporary directory as the cache directory of os.UserCacheDir, restored by the returned func
+func withTempCacheDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "ssgo-dynamic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCacheHome, oldTTL := os.Getenv("XDG_CACHE_HOME"), DynamicInventoryCacheTTL
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	return dir, func() {
+		os.Setenv("XDG_CACHE_HOME", oldCacheHome)
+		DynamicInventoryCacheTTL = oldTTL
+		os.RemoveAll(dir)
+	}
+}
+
+// an inventory script printing the output, each run appends a line to the runs file
+func writeInventoryScript(t *testing.T, dir, output string) (string, string) {
+	scriptPath, runsPath := filepath.Join(dir, "inventory.sh"), filepath.Join(dir, "runs")
+	script := "#!/bin/sh\necho run >> '" + runsPath + "'\ncat <<'EOF'\n" + output + "\nEOF\n"
+	if err := ioutil.WriteFile(scriptPath, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return scriptPath, runsPath
+}
+
+func scriptRuns(runsPath string) int {
+	buf, _ := ioutil.ReadFile(runsPath)
+	return strings.Count(string(buf), "run\n")
+}
+
+func TestLoadDynamicInventoryScript(t *testing.T) {
+	dir, restore := withTempCacheDir(t)
+	defer restore()
+	scriptPath, runsPath := writeInventoryScript(t, dir, dynamicInventoryJSON)
+	if !IsInventoryScript(scriptPath) || IsInventoryScript(runsPath) || IsInventoryScript(dir) {
+		t.Errorf("IsInventoryScript doesn't detect the executable script only")
+	}
+	cacheFile, err := dynamicInventoryCacheFile(scriptPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		ttl      time.Duration
+		age      time.Duration
+		wantRuns int
+	}{
+		{"first run", 5 * time.Minute, 0, 1},
+		{"cached", 5 * time.Minute, time.Minute, 1},
+		{"expired", 5 * time.Minute, 10 * time.Minute, 2},
+		{"no cache", 0, 0, 3},
+	}
+	for _, tt := range tests {
+		DynamicInventoryCacheTTL = tt.ttl
+		if tt.age > 0 {
+			modTime := time.Now().Add(-tt.age)
+			if err := os.Chtimes(cacheFile, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		inv, err := loadDynamicInventory(scriptPath, "script")
+		if err != nil {
+			t.Fatalf("%s: loadDynamicInventory failed, %s", tt.name, err)
+		}
+		if got, want := inventoryHostStrings(t, inv, "web"), []string{"192.168.100.1 admin:@22  ", "192.168.100.2 admin:@2222  "}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: hosts of web = %q, want %q", tt.name, got, want)
+		}
+		if runs := scriptRuns(runsPath); runs != tt.wantRuns {
+			t.Errorf("%s: the script runs %d times, want %d", tt.name, runs, tt.wantRuns)
+		}
+	}
+	if info, err := os.Stat(cacheFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("the cache file %s should be only readable by the current user, %v", cacheFile, err)
+	}
+}
+
+func TestLoadDynamicInventoryInvalidOutput(t *testing.T) {
+	dir, restore := withTempCacheDir(t)
+	defer restore()
+	scriptPath, runsPath := writeInventoryScript(t, dir, "not json")
+	for i := 0; i < 2; i++ {
+		if _, err := loadDynamicInventory(scriptPath, "script"); err == nil {
+			t.Fatalf("loadDynamicInventory doesn't fail for invalid output")
+		}
+	}
+	// the invalid output is never cached
+	if runs := scriptRuns(runsPath); runs != 2 {
+		t.Errorf("the script runs %d times, want 2", runs)
+	}
+}
+
+func TestLoadDynamicInventoryURL(t *testing.T) {
+	_, restore := withTempCacheDir(t)
+	defer restore()
+	DynamicInventoryCacheTTL = 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/inventory" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(dynamicInventoryJSON))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{server.URL + "/inventory", false},
+		{server.URL + "/missing", true},
+	}
+	for _, tt := range tests {
+		if !IsInventoryURL(tt.url) {
+			t.Errorf("IsInventoryURL(%s) = false", tt.url)
+		}
+		inv, err := loadDynamicInventory(tt.url, "http")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("loadDynamicInventory(%s) error = %v, want error %v", tt.url, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(inv.GroupNames(), []string{"web"}) {
+			t.Errorf("loadDynamicInventory(%s) groups = %v, want [web]", tt.url, inv.GroupNames())
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// yaml & json inventory file, e.g.
+//
+//	vars:
+//	  user: root
+//	  pass: root
+//	groups:
+//	  - name: web
+//	    vars:
+//	      port: 2222
+//	    hosts:
+//	      - 192.168.100.2,192.168.100.3-4
+//	      - host: 192.168.100.5
+//	        user: admin
+//	  - name: app
+//	    children: [web]
+type inventoryDocument struct {
+	Vars   map[string]interface{}   `yaml:"vars,omitempty" json:"vars,omitempty"`
+	Groups []inventoryDocumentGroup `yaml:"groups" json:"groups"`
+}
+
+type inventoryDocumentGroup struct {
+	Name     string                 `yaml:"name" json:"name"`
+	Vars     map[string]interface{} `yaml:"vars,omitempty" json:"vars,omitempty"`
+	Children []string               `yaml:"children,omitempty" json:"children,omitempty"`
+	Hosts    []interface{}          `yaml:"hosts,omitempty" json:"hosts,omitempty"`
+}
+
+// static inventory file formats
+var InventoryFormats = []string{"ini", "yaml", "json"}
+
+// inventory formats which can be loaded, including ansible inventory files, dynamic inventory script and url
+var InventorySourceFormats = []string{"ini", "yaml", "json", "ansible-ini", "ansible-yaml", "ansible-json", "script", "http"}
+
+// get inventory file format by file extension, "ini" by default
+func GetInventoryFormat(inventoryFilePath string) string {
+	switch strings.ToLower(filepath.Ext(inventoryFilePath)) {
+	case ".yml", ".yaml":
+		return "yaml"
+	case ".json":
+		return "json"
+	}
+	return "ini"
+}
+
+// detect inventory format by url scheme, file extension, executable permission and file content
+func DetectInventoryFormat(inventoryFilePath string) string {
+	if IsInventoryURL(inventoryFilePath) {
+		return "http"
+	}
+	format := GetInventoryFormat(inventoryFilePath)
+	switch strings.ToLower(filepath.Ext(inventoryFilePath)) {
+	case ".ini", ".yml", ".yaml", ".json":
+	default:
+		if IsInventoryScript(inventoryFilePath) {
+			return "script"
+		}
+	}
+	buf, err := ReadInventoryFile(inventoryFilePath)
+	if err != nil {
+		return format
+	}
+	switch {
+	case format == "ini" && IsAnsibleINIInventory(buf):
+		return "ansible-ini"
+	case format == "yaml" && IsAnsibleYAMLInventory(buf):
+		return "ansible-yaml"
+	case format == "json" && IsAnsibleJSONInventory(buf):
+		return "ansible-json"
+	}
+	return format
+}
+
+func loadDocumentInventory(inventoryFilePath, format string) (*Inventory, error) {
+	var doc inventoryDocument
+	buf, err := ReadInventoryFile(inventoryFilePath)
+	if err != nil {
+		return nil, err
+	}
+	if format == "yaml" {
+		err = yaml.Unmarshal(buf, &doc)
+	} else {
+		err = json.Unmarshal(buf, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s inventory file,please check:%v", format, err)
+	}
+	inv := &Inventory{Path: inventoryFilePath}
+	if inv.Vars, err = documentVars(doc.Vars, "vars"); err != nil {
+		return nil, err
+	}
+	for _, dg := range doc.Groups {
+		if dg.Name == "" {
+			return nil, fmt.Errorf("ERROR: host group without name found in %s", inventoryFilePath)
+		}
+		if dg.Name == inventoryAllGroup || dg.Name == inventoryVarsSection {
+			return nil, fmt.Errorf("ERROR: '%s' can't be used as a host group name in %s", dg.Name, inventoryFilePath)
+		}
+		g := &InventoryGroup{Name: dg.Name, Children: dg.Children}
+		if g.Vars, err = documentVars(dg.Vars, dg.Name); err != nil {
+			return nil, err
+		}
+		for _, item := range dg.Hosts {
+			entry, err := documentHostEntry(item, dg.Name)
+			entry.Err = err
+			g.Hosts = append(g.Hosts, entry)
+		}
+		inv.Groups = append(inv.Groups, g)
+	}
+	return inv, nil
+}
+
+// yaml decodes nested maps as map[interface{}]interface{}(or yaml.MapSlice), json as map[string]interface{}
+func documentMap(item interface{}) (map[string]interface{}, bool) {
+	switch m := item.(type) {
+	case map[string]interface{}:
+		return m, true
+	case yaml.MapSlice:
+		ret := map[string]interface{}{}
+		for _, kv := range m {
+			ret[fmt.Sprint(kv.Key)] = kv.Value
+		}
+		return ret, true
+	case map[interface{}]interface{}:
+		ret := map[string]interface{}{}
+		for k, v := range m {
+			ret[fmt.Sprint(k)] = v
+		}
+		return ret, true
+	}
+	return nil, false
+}
+
+func documentVars(m map[string]interface{}, groupName string) (map[string]string, error) {
+	vars := map[string]string{}
+	for k, v := range m {
+		if !isInventoryHostVarKey(k) {
+			return vars, fmt.Errorf("ERROR: unknown variable '%s' in host group '%s', valid variables are %s", k, groupName, strings.Join(InventoryHostVarKeys, ","))
+		}
+		vars[k] = documentString(v)
+	}
+	return vars, nil
+}
+
+// the scalar value as a string, empty values like "pass:" are decoded as nil
+func documentString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// host item is a hosts line like "192.168.100.5 port=2222", or a map like {host: 192.168.100.5, port: 2222}
+func documentHostEntry(item interface{}, groupName string) (InventoryHostEntry, error) {
+	if line, ok := item.(string); ok {
+		expr, vars, err := ParseHostLine(line)
+		return InventoryHostEntry{Expr: expr, Vars: vars}, err
+	}
+	m, ok := documentMap(item)
+	if !ok {
+		return InventoryHostEntry{}, fmt.Errorf("ERROR: invalid host item '%v' in host group '%s'", item, groupName)
+	}
+	expr, ok := m["host"]
+	if !ok {
+		return InventoryHostEntry{}, fmt.Errorf("ERROR: host item '%v' in host group '%s' has no 'host' key", item, groupName)
+	}
+	delete(m, "host")
+	vars, err := documentVars(m, groupName)
+	return InventoryHostEntry{Expr: documentString(expr), Vars: vars}, err
+}
+
+// port is kept as a number in yaml & json inventory file
+func documentVarsValue(vars map[string]string) map[string]interface{} {
+	if len(vars) == 0 {
+		return nil
+	}
+	m := map[string]interface{}{}
+	for k, v := range vars {
+		if port, err := strconv.Atoi(v); k == "port" && err == nil {
+			m[k] = port
+		} else {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// encode the inventory into ini, yaml or json format
+func EncodeInventory(inv *Inventory, format string) ([]byte, error) {
+	switch format {
+	case "ini":
+		return encodeINIInventory(inv), nil
+	case "yaml", "json":
+		doc := inventoryDocument{Vars: documentVarsValue(inv.Vars)}
+		for _, g := range inv.Groups {
+			dg := inventoryDocumentGroup{Name: g.Name, Vars: documentVarsValue(g.Vars), Children: g.Children}
+			for _, e := range g.Hosts {
+				if len(e.Vars) == 0 {
+					dg.Hosts = append(dg.Hosts, e.Expr)
+					continue
+				}
+				m := documentVarsValue(e.Vars)
+				m["host"] = e.Expr
+				dg.Hosts = append(dg.Hosts, m)
+			}
+			doc.Groups = append(doc.Groups, dg)
+		}
+		if format == "yaml" {
+			return yaml.Marshal(doc)
+		}
+		buf, err := json.MarshalIndent(doc, "", "    ")
+		if err != nil {
+			return buf, err
+		}
+		return append(buf, '\n'), nil
+	}
+	return nil, fmt.Errorf("ERROR: unsupported inventory format '%s', only %s is supported", format, strings.Join(InventoryFormats, ","))
+}
+
+func encodeINIInventory(inv *Inventory) []byte {
+	var buf bytes.Buffer
+	writeVars := func(vars map[string]string) {
+		for _, k := range InventoryHostVarKeys {
+			if v, ok := vars[k]; ok {
+				fmt.Fprintf(&buf, "%s = %s\n", k, v)
+			}
+		}
+	}
+	if len(inv.Vars) > 0 {
+		buf.WriteString("[" + inventoryVarsSection + "]\n")
+		writeVars(inv.Vars)
+		buf.WriteString("\n")
+	}
+	for _, g := range inv.Groups {
+		buf.WriteString("[" + g.Name + "]\n")
+		writeVars(g.Vars)
+		if len(g.Children) > 0 {
+			fmt.Fprintf(&buf, "children = %s\n", strings.Join(g.Children, ","))
+		}
+		switch len(g.Hosts) {
+		case 0:
+		case 1:
+			fmt.Fprintf(&buf, "hosts = %s\n", g.Hosts[0])
+		default:
+			buf.WriteString("hosts = \"\"\"\n")
+			for _, e := range g.Hosts {
+				buf.WriteString(e.String() + "\n")
+			}
+			buf.WriteString("\"\"\"\n")
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// write the inventory into a temporary file and load it back
+func roundTripInventory(t *testing.T, inv *Inventory, format string) *Inventory {
+	buf, err := EncodeInventory(inv, format)
+	if err != nil {
+		t.Fatalf("EncodeInventory(%s) failed, %s", format, err)
+	}
+	dir, err := ioutil.TempDir("", "ssgo-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	inventoryFilePath := filepath.Join(dir, "inventory."+format)
+	if err := ioutil.WriteFile(inventoryFilePath, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadDocumentInventory(inventoryFilePath, format)
+	if err != nil {
+		t.Fatalf("loadDocumentInventory(%s) failed, %s\n%s", format, err, buf)
+	}
+	return loaded
+}
+
+func TestEncodeInventoryRoundTrip(t *testing.T) {
+	inv := &Inventory{
+		Vars: map[string]string{"user": "root", "pass": "", "port": "22"},
+		Groups: []*InventoryGroup{
+			{Name: "web", Vars: map[string]string{"port": "2222"}, Hosts: []InventoryHostEntry{
+				{Expr: "192.168.100.2,192.168.100.3-192.168.100.4", Vars: map[string]string{}},
+				{Expr: "web01.example.com", Vars: map[string]string{"user": "admin", "port": "2200", "jump": "admin@192.168.100.1:22"}},
+			}},
+			{Name: "db", Vars: map[string]string{"key": "~/.ssh/db.pem"}, Hosts: []InventoryHostEntry{
+				{Expr: "192.168.100.5", Vars: map[string]string{"pass": "p a#ss"}},
+			}},
+			{Name: "prod", Vars: map[string]string{}, Children: []string{"web", "db"}},
+		},
+	}
+	for _, format := range []string{"yaml", "json"} {
+		loaded := roundTripInventory(t, inv, format)
+		if !reflect.DeepEqual(loaded.Vars, inv.Vars) {
+			t.Errorf("%s: vars = %v, want %v", format, loaded.Vars, inv.Vars)
+		}
+		if got, want := loaded.GroupNames(), inv.GroupNames(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: group names = %v, want %v", format, got, want)
+		}
+		for i, g := range loaded.Groups {
+			want := inv.Groups[i]
+			if !reflect.DeepEqual(g.Vars, want.Vars) || !reflect.DeepEqual(g.Children, want.Children) {
+				t.Errorf("%s: group %s vars %v children %v, want %v %v", format, g.Name, g.Vars, g.Children, want.Vars, want.Children)
+			}
+			if len(g.Hosts) != len(want.Hosts) {
+				t.Errorf("%s: group %s has %d hosts, want %d", format, g.Name, len(g.Hosts), len(want.Hosts))
+				continue
+			}
+			for j, e := range g.Hosts {
+				if e.Expr != want.Hosts[j].Expr || !reflect.DeepEqual(e.Vars, want.Hosts[j].Vars) || e.Err != nil {
+					t.Errorf("%s: host %d of %s = %q %v %v, want %q %v", format, j, g.Name, e.Expr, e.Vars, e.Err, want.Hosts[j].Expr, want.Hosts[j].Vars)
+				}
+			}
+		}
+	}
+}
+
+func TestDocumentHostEntry(t *testing.T) {
+	tests := []struct {
+		item     interface{}
+		wantExpr string
+		wantVars map[string]string
+		wantErr  bool
+	}{
+		{"192.168.100.5 port=2222 user=admin", "192.168.100.5", map[string]string{"port": "2222", "user": "admin"}, false},
+		{map[string]interface{}{"host": "192.168.100.5", "port": 2222, "pass": nil}, "192.168.100.5", map[string]string{"port": "2222", "pass": ""}, false},
+		{map[interface{}]interface{}{"host": "web01", "user": "admin"}, "web01", map[string]string{"user": "admin"}, false},
+		{map[string]interface{}{"port": 2222}, "", nil, true},
+		{map[string]interface{}{"host": "web01", "password": "secret"}, "web01", nil, true},
+		{[]interface{}{"web01"}, "", nil, true},
+	}
+	for _, tt := range tests {
+		e, err := documentHostEntry(tt.item, "web")
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("documentHostEntry(%v) doesn't fail", tt.item)
+			}
+			continue
+		}
+		if err != nil || e.Expr != tt.wantExpr || !reflect.DeepEqual(e.Vars, tt.wantVars) {
+			t.Errorf("documentHostEntry(%v) = %q %v %v, want %q %v", tt.item, e.Expr, e.Vars, err, tt.wantExpr, tt.wantVars)
+		}
+	}
+}
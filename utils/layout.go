@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// how downloaded files are laid out in the local directory, "prefix" saves them as "<host>_<name>",
+// "dir" saves them as "<host>/<remote path>", other layouts are templates like "{{.Group}}/{{.Host}}/{{.Base}}"
+var DownloadLayouts = []string{"prefix", "dir", "template"}
+
+var DownloadLayout = "prefix"
+
+var downloadTemplate *template.Template
+
+// the fields of the download layout template
+type DownloadLayoutData struct {
+	Host  string
+	Group string
+	// the remote path of the file, its directory and name
+	Path string
+	Dir  string
+	Base string
+}
+
+// set DownloadLayout by "prefix", "dir" or a template, the template must lay out the files of
+// different hosts apart
+func SetDownloadLayout(layout string) error {
+	if layout == "prefix" || layout == "dir" {
+		DownloadLayout = layout
+		return nil
+	}
+	if !strings.Contains(layout, "{{") {
+		return fmt.Errorf("ERROR: invalid layout \"%s\", one of prefix, dir or a template like \"{{.Group}}/{{.Host}}/{{.Base}}\"", layout)
+	}
+	tpl, err := template.New("layout").Option("missingkey=error").Parse(layout)
+	if err != nil {
+		return fmt.Errorf("ERROR: invalid layout template \"%s\", %s", layout, err)
+	}
+	var paths []string
+	for _, host := range []string{"192.168.100.1", "192.168.100.2"} {
+		p, err := executeLayout(tpl, DownloadLayoutData{Host: host, Path: "/tmp/a.txt", Dir: "/tmp", Base: "a.txt"})
+		if err != nil {
+			return fmt.Errorf("ERROR: invalid layout template \"%s\", %s", layout, err)
+		}
+		paths = append(paths, p)
+	}
+	if paths[0] == paths[1] {
+		return fmt.Errorf("ERROR: layout template \"%s\" doesn't lay out the files of different hosts apart, use {{.Host}} in it", layout)
+	}
+	DownloadLayout = "template"
+	downloadTemplate = tpl
+	return nil
+}
+
+func executeLayout(tpl *template.Template, data DownloadLayoutData) (string, error) {
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	p := strings.TrimSpace(buf.String())
+	if p == "" {
+		return "", fmt.Errorf("the layout of %s is empty", data.Path)
+	}
+	return filepath.FromSlash(p), nil
+}
+
+// the local path of the remote file or directory by DownloadLayout, root is the downloaded remote path
+// which contains remotePath
+func (t *sftpTransfer) layoutPath(localDir, root, rootName, remotePath string) (string, error) {
+	switch DownloadLayout {
+	case "dir":
+		// ".." of the relative remote path is cleaned as if it's under "/", so it's kept in the host directory
+		hostDir := filepath.Join(localDir, t.host)
+		return layoutWithin(hostDir, filepath.Join(hostDir, filepath.FromSlash(path.Join("/", remotePath))))
+	case "template":
+		p, err := executeLayout(downloadTemplate, DownloadLayoutData{
+			Host:  t.host,
+			Group: t.group,
+			Path:  remotePath,
+			Dir:   path.Dir(remotePath),
+			Base:  path.Base(remotePath),
+		})
+		if err != nil {
+			return "", err
+		}
+		return layoutWithin(localDir, filepath.Join(localDir, p))
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(remotePath, root), "/")
+	return filepath.Join(localDir, fmt.Sprintf("%s_%s", t.host, rootName), filepath.FromSlash(rel)), nil
+}
+
+// the laid out path must be in the directory, e.g. ".." of the remote path or the template doesn't leave it
+func layoutWithin(dir, p string) (string, error) {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("the laid out path %s is out of %s", p, dir)
+	}
+	return p, nil
+}
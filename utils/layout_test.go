@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSetDownloadLayout(t *testing.T) {
+	defer SetDownloadLayout("prefix")
+	tests := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{"prefix", "prefix", false},
+		{"dir", "dir", false},
+		{"{{.Group}}/{{.Host}}/{{.Base}}", "template", false},
+		{"{{.Host}}_{{.Base}}", "template", false},
+		{"flat", "", true},
+		{"", "", true},
+		{"{{.Base}}", "", true},
+		{"{{.Unknown}}/{{.Host}}", "", true},
+		{"{{.Host", "", true},
+	}
+	for _, tt := range tests {
+		DownloadLayout = "prefix"
+		err := SetDownloadLayout(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("SetDownloadLayout(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if err == nil && DownloadLayout != tt.want {
+			t.Errorf("SetDownloadLayout(%q) sets %q, want %q", tt.in, DownloadLayout, tt.want)
+		}
+	}
+}
+
+func TestLayoutPath(t *testing.T) {
+	defer SetDownloadLayout("prefix")
+	tr := &sftpTransfer{host: "192.168.100.1", group: "web"}
+	tests := []struct {
+		layout, root, rootName, remotePath string
+		want                               string
+		err                                bool
+	}{
+		{"prefix", "/var/log/app", "app", "/var/log/app/a.log", "out/192.168.100.1_app/a.log", false},
+		{"prefix", "/var/log/app.log", "app.log", "/var/log/app.log", "out/192.168.100.1_app.log", false},
+		{"dir", "/var/log/app", "app", "/var/log/app/a.log", "out/192.168.100.1/var/log/app/a.log", false},
+		{"dir", "logs", "logs", "logs/a.log", "out/192.168.100.1/logs/a.log", false},
+		{"dir", "../../etc", "etc", "../../etc/passwd", "out/192.168.100.1/etc/passwd", false},
+		{"{{.Group}}/{{.Host}}/{{.Base}}", "/var/log/app", "app", "/var/log/app/a.log", "out/web/192.168.100.1/a.log", false},
+		{"{{.Host}}/{{.Path}}", "../../etc", "etc", "../../etc/passwd", "", true},
+	}
+	for _, tt := range tests {
+		if err := SetDownloadLayout(tt.layout); err != nil {
+			t.Fatalf("SetDownloadLayout(%q) failed, %s", tt.layout, err)
+		}
+		got, err := tr.layoutPath("out", tt.root, tt.rootName, tt.remotePath)
+		if (err != nil) != tt.err {
+			t.Errorf("layoutPath(%s) by %s error = %v, want error %v", tt.remotePath, tt.layout, err, tt.err)
+			continue
+		}
+		if err == nil && got != filepath.FromSlash(tt.want) {
+			t.Errorf("layoutPath(%s) by %s = %s, want %s", tt.remotePath, tt.layout, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progress display modes, "auto" is "tty" if stdout is a terminal, otherwise "plain"
+var ProgressModes = []string{"auto", "tty", "plain", "none"}
+
+// the progress of running hosts, drawn on stderr so the results on stdout are never corrupted,
+// "tty" redraws a single line, "plain" prints a progress line periodically
+type ProgressDisplay struct {
+	pool     *WorkerPool
+	total    int
+	tty      bool
+	interval time.Duration
+	start    time.Time
+
+	mu        sync.Mutex
+	counts    map[string]int
+	shown     bool
+	suspended bool
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// start the progress display of total hosts run by the pool, returns nil if mode is "none",
+// the methods of nil ProgressDisplay do nothing
+func NewProgressDisplay(pool *WorkerPool, total int, mode string, interval time.Duration) *ProgressDisplay {
+	if mode == "auto" {
+		mode = "plain"
+		if IsStdoutTerminal() {
+			mode = "tty"
+		}
+	}
+	if mode == "none" || total == 0 {
+		return nil
+	}
+	p := &ProgressDisplay{
+		pool:     pool,
+		total:    total,
+		tty:      mode == "tty",
+		interval: interval,
+		start:    time.Now(),
+		counts:   map[string]int{},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if p.tty {
+		p.interval = 200 * time.Millisecond
+	}
+	if p.interval <= 0 {
+		p.interval = 10 * time.Second
+	}
+	go p.run()
+	return p
+}
+
+func (p *ProgressDisplay) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.mu.Lock()
+			if !p.suspended {
+				p.draw()
+			}
+			p.mu.Unlock()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// count the result of a host by its status
+func (p *ProgressDisplay) AddResult(status string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.counts[status]++
+	p.mu.Unlock()
+}
+
+// e.g. "Progress: 120/500 done, 100 success, 20 failed, 20 running, 4.2 hosts/s, ETA 1m30s"
+func (p *ProgressDisplay) line() string {
+	done := 0
+	for _, n := range p.counts {
+		done += n
+	}
+	items := []string{fmt.Sprintf("%d/%d done", done, p.total)}
+	for _, status := range []string{"success", "failed", "corrupt", "skipped", "cancelled"} {
+		if n := p.counts[status]; n > 0 || status == "success" || status == "failed" {
+			items = append(items, fmt.Sprintf("%d %s", n, status))
+		}
+	}
+	items = append(items, fmt.Sprintf("%d running", p.pool.Progress().Running))
+	elapsed := time.Since(p.start)
+	rate := float64(done) / elapsed.Seconds()
+	items = append(items, fmt.Sprintf("%.1f hosts/s", rate))
+	if rate > 0 && done < p.total {
+		eta := time.Duration(float64(p.total-done) / rate * float64(time.Second))
+		items = append(items, "ETA "+eta.Round(time.Second).String())
+	}
+	return "Progress: " + strings.Join(items, ", ")
+}
+
+func (p *ProgressDisplay) draw() {
+	if p.tty {
+		fmt.Fprint(os.Stderr, "\r\033[K"+p.line())
+		p.shown = true
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s %s\n", time.Now().Format("2006-01-02 15:04:05"), p.line())
+}
+
+// clear the progress line in terminal
+func (p *ProgressDisplay) clear() {
+	if p.shown {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		p.shown = false
+	}
+}
+
+// clear the progress line and stop drawing it until Resume, e.g. before prompting in terminal
+func (p *ProgressDisplay) Suspend() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.suspended = true
+	p.clear()
+	p.mu.Unlock()
+}
+
+func (p *ProgressDisplay) Resume() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.suspended = false
+	p.mu.Unlock()
+}
+
+// print the results without the progress line mixed in
+func (p *ProgressDisplay) WithoutProgress(fn func()) {
+	if p == nil {
+		fn()
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	fn()
+}
+
+// stop the progress display and clear the progress line, it's safe to call more than once
+func (p *ProgressDisplay) Stop() {
+	if p == nil {
+		return
+	}
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		<-p.done
+		p.mu.Lock()
+		p.clear()
+		p.mu.Unlock()
+	})
+}
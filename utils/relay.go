@@ -0,0 +1,374 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the relay action uploads the file to the seed hosts only, then every host having the file sends it to another
+// host by the sftp command on it, so the hosts having the file double in each round like a tree.
+// the sending host logs in to the receiving host by a temporary key of the run, which is added to the
+// authorized_keys of the receiving host before sending and removed after it
+type RelayTree struct {
+	localPath string
+	localInfo os.FileInfo
+	digest    string
+	seeds     map[string]bool
+	// the temporary key, the private key is sent to the sending host by stdin and never stored there
+	privateKey []byte
+	publicKey  string
+	keyComment string
+
+	mu           sync.Mutex
+	cond         *sync.Cond
+	sources      []relaySource
+	seedsPending int
+	seedFailures map[string]int
+	busy         int
+}
+
+// the failed seed hosts are rerun this many times by --retry-on all, the hosts waiting for them keep waiting
+var RelaySeedRetries int
+
+// the host having the file and the remote path of the file
+type relaySource struct {
+	host       Host
+	remotePath string
+}
+
+// create the relay tree of the local file, the first seeds hosts are uploaded from the local machine
+func NewRelayTree(localPath string, hosts []Host, seeds int) (*RelayTree, error) {
+	if isGlobPattern(localPath) {
+		return nil, fmt.Errorf("ERROR: the relay action transfers a single file, glob pattern %s is not supported", localPath)
+	}
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: %s", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("ERROR: the relay action transfers a single file, %s is not a regular file", localPath)
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: %s", err)
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return nil, fmt.Errorf("ERROR: read %s failed, %s", localPath, err)
+	}
+	r := &RelayTree{localPath: localPath, localInfo: info, digest: hexDigest(hasher), seeds: map[string]bool{}, seedFailures: map[string]int{}}
+	r.cond = sync.NewCond(&r.mu)
+	for i := 0; i < seeds && i < len(hosts); i++ {
+		r.seeds[hosts[i].Address] = true
+	}
+	r.seedsPending = len(r.seeds)
+	if err := r.generateKey(); err != nil {
+		return nil, fmt.Errorf("ERROR: generate the relay key failed, %s", err)
+	}
+	return r, nil
+}
+
+func (r *RelayTree) generateKey() error {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	random := make([]byte, 4)
+	rand.Read(random)
+	r.keyComment = fmt.Sprintf("ssgo-relay-%s-%x", time.Now().Format("20060102-150405"), random)
+	block, err := ssh.MarshalPrivateKey(priv, r.keyComment)
+	if err != nil {
+		return err
+	}
+	r.privateKey = pem.EncodeToMemory(block)
+	r.publicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+	return nil
+}
+
+// the line of authorized_keys on the receiving host, the key only logs in from the sending host, the comment is
+// unique for each host so it's removed exactly
+func (r *RelayTree) authorizedKey(h, source Host) string {
+	return fmt.Sprintf("restrict,from=\"%s\" %s %s-%s", relayFromPatterns(source.Address), r.publicKey, r.keyComment, h.Address)
+}
+
+// the from="" patterns of the sending host, sshd matches the client by its IP address unless UseDNS is set,
+// so the host names are resolved locally and the IP addresses are added
+func relayFromPatterns(address string) string {
+	if net.ParseIP(address) != nil {
+		return address
+	}
+	ips, err := net.LookupHost(address)
+	if err != nil {
+		return address
+	}
+	return strings.Join(append(ips, address), ",")
+}
+
+// quote the path for the sftp batch file, the quoted glob characters are matched as they are
+func sftpBatchQuote(p string) string {
+	return "\"" + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(p) + "\""
+}
+
+// wait for a host having the file, it fails if none is left
+func (r *RelayTree) acquire(ctx context.Context) (relaySource, error) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.mu.Lock()
+			r.cond.Broadcast()
+			r.mu.Unlock()
+		case <-stop:
+		}
+	}()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.sources) == 0 {
+		if ctx.Err() != nil {
+			return relaySource{}, fmt.Errorf("cancelled while waiting for a host to relay from")
+		}
+		if r.seedsPending == 0 && r.busy == 0 {
+			return relaySource{}, fmt.Errorf("no host has the file to relay from, the seed hosts failed")
+		}
+		r.cond.Wait()
+	}
+	source := r.sources[0]
+	r.sources = r.sources[1:]
+	r.busy++
+	return source, nil
+}
+
+// give back the source after sending, a broken source is not used anymore, the receiving host
+// becomes a source if it has the file
+func (r *RelayTree) release(source relaySource, broken bool, received *relaySource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.busy--
+	if !broken {
+		r.sources = append(r.sources, source)
+	}
+	if received != nil {
+		r.sources = append(r.sources, *received)
+	}
+	r.cond.Broadcast()
+}
+
+// the seed host is done, seed is nil if it failed, the failed seed host is still pending if it's rerun by
+// RelaySeedRetries and ssgo isn't interrupted
+func (r *RelayTree) seedDone(ctx context.Context, h Host, seed *relaySource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if seed == nil && ctx.Err() == nil {
+		r.seedFailures[h.Address]++
+		if r.seedFailures[h.Address] <= RelaySeedRetries {
+			return
+		}
+	}
+	if r.seedsPending > 0 {
+		r.seedsPending--
+	}
+	if seed != nil {
+		r.sources = append(r.sources, *seed)
+	}
+	r.cond.Broadcast()
+}
+
+// upload the file to the seed host, or relay it from another host having the file
+func SFTPRelay(ctx context.Context, h Host, tree *RelayTree, destinationPath string, chr chan interface{}) {
+	var sftpResult SFTPResult
+	sftpResult.Host = h.Address
+	sftpResult.SourcePath = tree.localPath
+	sftpResult.DestinationPath = destinationPath
+	t, err := newSFTPTransfer(ctx, h)
+	if err != nil {
+		if tree.seeds[h.Address] {
+			tree.seedDone(ctx, h, nil)
+		}
+		sftpResult.Status = "failed"
+		sftpResult.Result = fmt.Sprintf("ERROR: sftp connect to %s failed, error message:%s", sftpResult.Host, err.Error())
+		chr <- sftpResult
+		return
+	}
+	defer t.Close()
+	if destinationPath == "" {
+		currWorkDir, _ := t.sftpClient.Getwd()
+		sftpResult.DestinationPath = currWorkDir
+	}
+	received := &relaySource{host: h, remotePath: path.Join(sftpResult.DestinationPath, filepath.Base(tree.localPath))}
+
+	// the sending hosts connect to the receiving host directly, so the hosts behind a jump host are uploaded
+	// from the local machine like the seed hosts, but they aren't relayed from unless they are seed hosts
+	if seed := tree.seeds[h.Address]; seed || h.Jump != "" {
+		role := "seed host"
+		if !seed {
+			role = "host behind jump host " + h.Jump
+		}
+		if err = t.sftpClient.MkdirAll(path.Dir(received.remotePath)); err != nil {
+			err = fmt.Errorf("create remote directory %s failed, %s", path.Dir(received.remotePath), err)
+		}
+		if err == nil {
+			err = t.uploadFile(tree.localPath, received.remotePath)
+		}
+		// the uploaded file is compared with the local file already by --verify sha256 or --resume
+		if err == nil && TransferVerify != "sha256" && !TransferResume {
+			err = t.checkRelayDigest(tree, received.remotePath)
+		}
+		if err != nil {
+			received = nil
+		}
+		if seed {
+			tree.seedDone(ctx, h, received)
+		}
+		t.setResult(&sftpResult)
+		if err != nil {
+			sftpResult.Status = transferErrorStatus(err)
+			sftpResult.Result = fmt.Sprintf("ERROR: while upload file \"%s\" to %s remote path \"%s\" ,error message:%s ", sftpResult.SourcePath, role, sftpResult.DestinationPath, sftpError(ctx, err))
+			chr <- sftpResult
+			return
+		}
+		sftpResult.Status = "success"
+		sftpResult.Result = fmt.Sprintf("Upload finished!:) %s, %d file(s), %s, %s", role, sftpResult.Files, formatBytes(sftpResult.Bytes), t.throughput())
+		chr <- sftpResult
+		return
+	}
+
+	source, err := tree.acquire(ctx)
+	if err != nil {
+		sftpResult.Status = "failed"
+		sftpResult.Result = fmt.Sprintf("ERROR: while relay file \"%s\" to remote path \"%s\" ,error message:%s ", sftpResult.SourcePath, sftpResult.DestinationPath, err)
+		chr <- sftpResult
+		return
+	}
+	// the throughput is counted from the start of sending
+	t.start = time.Now()
+	broken, err := t.relayFrom(tree, source, received.remotePath)
+	if err != nil {
+		received = nil
+	}
+	tree.release(source, broken, received)
+	t.setResult(&sftpResult)
+	if err != nil {
+		sftpResult.Status = transferErrorStatus(err)
+		sftpResult.Result = fmt.Sprintf("ERROR: while relay file \"%s\" from host %s to remote path \"%s\" ,error message:%s ", sftpResult.SourcePath, source.host.Address, sftpResult.DestinationPath, sftpError(ctx, err))
+		chr <- sftpResult
+		return
+	}
+	sftpResult.Status = "success"
+	sftpResult.Result = fmt.Sprintf("Relay finished!:) from host %s, %d file(s), %s, %s", source.host.Address, sftpResult.Files, formatBytes(sftpResult.Bytes), t.throughput())
+	chr <- sftpResult
+	return
+}
+
+// the bandwidth limit of the sftp command on the relay host in Kbit/s, 0 means no limit. the relays between the
+// remote hosts can't share TotalBandwidthLimit, so each of them is limited by it alone
+func relayBandwidthLimit() int64 {
+	limit := BandwidthLimit
+	if TotalBandwidthLimit > 0 && (limit == 0 || TotalBandwidthLimit < limit) {
+		limit = TotalBandwidthLimit
+	}
+	if limit == 0 {
+		return 0
+	}
+	if kbps := limit * 8 / 1000; kbps > 0 {
+		return kbps
+	}
+	return 1
+}
+
+// compare the sha256 of the remote file with the local file
+func (t *sftpTransfer) checkRelayDigest(tree *RelayTree, remotePath string) error {
+	digest, err := t.remoteSHA256(remotePath)
+	if err != nil {
+		return err
+	}
+	if digest != tree.digest {
+		return &corruptError{fmt.Sprintf("sha256 mismatch, %s is %s, remote %s is %s", tree.localPath, tree.digest, targetPath(remotePath), digest)}
+	}
+	if TransferVerify == "sha256" {
+		t.digests = append(t.digests, fmt.Sprintf("%s  %s", digest, targetPath(remotePath)))
+	}
+	return nil
+}
+
+// send the file from the source host to this host by the sftp command on the source host, broken is true if
+// the source host can't be connected
+func (t *sftpTransfer) relayFrom(tree *RelayTree, source relaySource, remotePath string) (broken bool, err error) {
+	if remotePath, err = t.resolveLink(remotePath); err != nil {
+		return false, err
+	}
+	tmpPath := tempPath(remotePath)
+	if strings.ContainsAny(source.remotePath+remotePath, "\r\n") {
+		return false, fmt.Errorf("the relay action doesn't support remote paths with newlines")
+	}
+	authorizedKey := tree.authorizedKey(Host{Address: t.host}, source.host)
+	// add a newline first if the last line doesn't end with it like ssh-copy-id, or the key is joined to it
+	install := fmt.Sprintf("mkdir -p -- %s && (umask 077 && mkdir -p ~/.ssh && f=~/.ssh/authorized_keys && "+
+		"{ [ -z \"$(tail -c 1 \"$f\" 2>/dev/null)\" ] || echo >> \"$f\"; } && printf '%%s\\n' %s >> \"$f\")",
+		shellQuote(path.Dir(remotePath)), shellQuote(authorizedKey))
+	if _, err := t.runOutput(install); err != nil {
+		return false, fmt.Errorf("add the relay key to ~/.ssh/authorized_keys failed, %s", err)
+	}
+	// grep exits 1 if no line is left, the file is kept as it is if grep fails otherwise
+	defer t.runOutput(fmt.Sprintf("umask 077 && f=~/.ssh/authorized_keys && { grep -v -x -F -- %s \"$f\" > \"$f.ssgo-tmp\"; [ $? -le 1 ]; } && "+
+		"cat \"$f.ssgo-tmp\" > \"$f\" && { [ -s \"$f\" ] || rm -f \"$f\"; }; rm -f ~/.ssh/authorized_keys.ssgo-tmp",
+		shellQuote(authorizedKey)))
+
+	sourceClient, err := sshDialWithRetry(t.ctx, source.host, 30*time.Second)
+	if err != nil {
+		return true, fmt.Errorf("connect to relay host %s failed, %s", source.host.Address, err)
+	}
+	defer sourceClient.Close()
+	session, err := sourceClient.NewSession()
+	if err != nil {
+		return true, fmt.Errorf("connect to relay host %s failed, %s", source.host.Address, err)
+	}
+	defer session.Close()
+	var errBuffer bytes.Buffer
+	session.Stdin = bytes.NewReader(tree.privateKey)
+	session.Stderr = &errBuffer
+	// the private key is read from stdin into a temporary file only readable by the login user, and removed after sending
+	batch := fmt.Sprintf("put %s %s", sftpBatchQuote(source.remotePath), sftpBatchQuote(tmpPath))
+	var limit string
+	if kbps := relayBandwidthLimit(); kbps > 0 {
+		limit = fmt.Sprintf("-l %d ", kbps)
+	}
+	cmd := fmt.Sprintf("umask 077; k=$(mktemp) && b=$(mktemp) || exit 1; cat > \"$k\"; printf '%%s\\n' %s > \"$b\"; "+
+		"sftp -b \"$b\" -i \"$k\" %s-P %d -o BatchMode=yes -o IdentitiesOnly=yes -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o LogLevel=ERROR %s; "+
+		"rc=$?; rm -f \"$k\" \"$b\"; exit $rc",
+		shellQuote(batch), limit, t.port, shellQuote(t.user+"@"+t.host))
+	if err := runSession(t.ctx, session, cmd); err != nil {
+		t.sftpClient.Remove(tmpPath)
+		return false, fmt.Errorf("sftp on relay host %s failed, %s %s", source.host.Address, err, strings.TrimSpace(errBuffer.String()))
+	}
+	if err := t.checkRelayDigest(tree, tmpPath); err != nil {
+		t.sftpClient.Remove(tmpPath)
+		return false, err
+	}
+	if err := t.replace(tmpPath, remotePath, tree.localInfo); err != nil {
+		t.sftpClient.Remove(tmpPath)
+		return false, err
+	}
+	t.files++
+	t.bytes += tree.localInfo.Size()
+	return false, nil
+}
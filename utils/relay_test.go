@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func newTestRelayTree(t *testing.T, hosts []Host, seeds int) (*RelayTree, func()) {
+	dir, err := ioutil.TempDir("", "ssgo-relay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	localPath := filepath.Join(dir, "app.tar.gz")
+	if err := ioutil.WriteFile(localPath, []byte("app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := NewRelayTree(localPath, hosts, seeds)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("NewRelayTree failed, %s", err)
+	}
+	return tree, func() { os.RemoveAll(dir) }
+}
+
+func TestNewRelayTree(t *testing.T) {
+	hosts := []Host{{Address: "192.168.100.1"}, {Address: "192.168.100.2"}, {Address: "192.168.100.3"}}
+	tree, cleanup := newTestRelayTree(t, hosts, 2)
+	defer cleanup()
+	if want := map[string]bool{"192.168.100.1": true, "192.168.100.2": true}; !reflect.DeepEqual(tree.seeds, want) {
+		t.Errorf("seeds = %v, want %v", tree.seeds, want)
+	}
+	if tree.seedsPending != 2 {
+		t.Errorf("seedsPending = %d, want 2", tree.seedsPending)
+	}
+	if tree.digest != "a172cedcae47474b615c54d510a5d84a8dea3032e958587430b413538be3f333" {
+		t.Errorf("digest = %s, want the sha256 of the file", tree.digest)
+	}
+	if !regexp.MustCompile(`^ssgo-relay-\d{8}-\d{6}-[0-9a-f]{8}$`).MatchString(tree.keyComment) {
+		t.Errorf("keyComment = %s, want ssgo-relay-<timestamp>-<random>", tree.keyComment)
+	}
+
+	more, cleanupMore := newTestRelayTree(t, hosts[:1], 3)
+	defer cleanupMore()
+	if more.seedsPending != 1 {
+		t.Errorf("seedsPending of more seeds than hosts = %d, want 1", more.seedsPending)
+	}
+
+	dir := filepath.Dir(tree.localPath)
+	for _, localPath := range []string{filepath.Join(dir, "*.tar.gz"), dir, filepath.Join(dir, "missing")} {
+		if _, err := NewRelayTree(localPath, hosts, 1); err == nil {
+			t.Errorf("NewRelayTree(%s) doesn't fail", localPath)
+		}
+	}
+}
+
+func TestRelayAuthorizedKey(t *testing.T) {
+	tree := &RelayTree{publicKey: "ssh-ed25519 AAAA", keyComment: "ssgo-relay-20261019-180000-0a1b2c3d"}
+	got := tree.authorizedKey(Host{Address: "192.168.100.2"}, Host{Address: "192.168.100.1"})
+	want := `restrict,from="192.168.100.1" ssh-ed25519 AAAA ssgo-relay-20261019-180000-0a1b2c3d-192.168.100.2`
+	if got != want {
+		t.Errorf("authorizedKey = %s, want %s", got, want)
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"192.168.100.1", "192.168.100.1"},
+		{"fe80::1", "fe80::1"},
+		{"localhost", "127.0.0.1,localhost"},
+		{"no-such-host.invalid", "no-such-host.invalid"},
+	}
+	for _, tt := range tests {
+		got := relayFromPatterns(tt.in)
+		// localhost may resolve to ::1 as well
+		if tt.in == "localhost" && regexp.MustCompile(`^([0-9a-f:.]+,)+localhost$`).MatchString(got) {
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("relayFromPatterns(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSFTPBatchQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"/opt/app.tar.gz", `"/opt/app.tar.gz"`},
+		{"/opt/my app/*.tar.gz", `"/opt/my app/*.tar.gz"`},
+		{`/opt/"app".tar.gz`, `"/opt/\"app\".tar.gz"`},
+		{`/opt/app\.tar.gz`, `"/opt/app\\.tar.gz"`},
+	}
+	for _, tt := range tests {
+		if got := sftpBatchQuote(tt.in); got != tt.want {
+			t.Errorf("sftpBatchQuote(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+// acquire in background, the result is sent to the returned channel
+func acquireAsync(ctx context.Context, tree *RelayTree) chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := tree.acquire(ctx)
+		done <- err
+	}()
+	return done
+}
+
+func waitAcquire(t *testing.T, done chan error, name string) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s: acquire doesn't return", name)
+		return nil
+	}
+}
+
+func TestRelayTreeSources(t *testing.T) {
+	defer func(retries int) { RelaySeedRetries = retries }(RelaySeedRetries)
+	RelaySeedRetries = 1
+	seed1, seed2, host := Host{Address: "192.168.100.1"}, Host{Address: "192.168.100.2"}, Host{Address: "192.168.100.3"}
+	tree, cleanup := newTestRelayTree(t, []Host{seed1, seed2, host}, 2)
+	defer cleanup()
+	ctx := context.Background()
+
+	done := acquireAsync(ctx, tree)
+	// the first failure of a seed host is rerun, so it's still pending
+	tree.seedDone(ctx, seed1, nil)
+	tree.seedDone(ctx, seed2, &relaySource{seed2, "/opt/app.tar.gz"})
+	if err := waitAcquire(t, done, "seed done"); err != nil {
+		t.Fatalf("acquire failed, %s", err)
+	}
+	if tree.seedsPending != 1 || tree.busy != 1 {
+		t.Errorf("seedsPending = %d, busy = %d, want 1, 1", tree.seedsPending, tree.busy)
+	}
+	// the broken source is dropped, the receiving host becomes a source
+	tree.release(relaySource{seed2, "/opt/app.tar.gz"}, true, &relaySource{host, "/opt/app.tar.gz"})
+	if want := []relaySource{{host, "/opt/app.tar.gz"}}; !reflect.DeepEqual(tree.sources, want) {
+		t.Errorf("sources = %v, want %v", tree.sources, want)
+	}
+	source, err := tree.acquire(ctx)
+	if err != nil || source.host.Address != host.Address {
+		t.Fatalf("acquire = %v, %v, want %s", source, err, host.Address)
+	}
+	tree.release(source, true, nil)
+
+	// no source is left once the rerun seed host failed again
+	done = acquireAsync(ctx, tree)
+	tree.seedDone(ctx, seed1, nil)
+	if err := waitAcquire(t, done, "seed failed"); err == nil {
+		t.Errorf("acquire doesn't fail without any source")
+	}
+
+	// the waiting host gives up when ssgo is interrupted
+	waiting, cleanupWaiting := newTestRelayTree(t, []Host{seed1, host}, 1)
+	defer cleanupWaiting()
+	cancelCtx, cancel := context.WithCancel(ctx)
+	done = acquireAsync(cancelCtx, waiting)
+	cancel()
+	if err := waitAcquire(t, done, "cancelled"); err == nil {
+		t.Errorf("acquire doesn't fail when cancelled")
+	}
+}
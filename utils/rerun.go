@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// hosts not finished successfully are written to ssgo-failed-<timestamp>.txt in current directory after each run,
+// the file can be used by --host-file or --rerun-failed
+const failedHostsFilePrefix = "ssgo-failed-"
+
+type FailedHost struct {
+	Address string
+	Status  string
+}
+
+// write the failed, corrupt, skipped and cancelled hosts to a new failed hosts file, one host per line and grouped by status
+func WriteFailedHostsFile(hosts []FailedHost, command string) (string, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# hosts not finished successfully by: %s\n", command)
+	written := map[string]bool{}
+	for _, status := range []string{"failed", "corrupt", "skipped", "cancelled"} {
+		header := false
+		for _, h := range hosts {
+			if h.Status != status || written[h.Address] {
+				continue
+			}
+			if !header {
+				fmt.Fprintf(&buf, "# %s\n", status)
+				header = true
+			}
+			buf.WriteString(h.Address + "\n")
+			written[h.Address] = true
+		}
+	}
+	// the random suffix keeps the files of the runs or host groups finished in the same millisecond apart,
+	// the files still sort by the time
+	f, err := ioutil.TempFile(".", failedHostsFilePrefix+time.Now().Format("20060102-150405.000")+"-*.txt")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	path := filepath.Base(f.Name())
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return path, err
+	}
+	return path, f.Chmod(0644)
+}
+
+// the latest failed hosts file in current directory
+func LatestFailedHostsFile() (string, error) {
+	files, err := filepath.Glob(failedHostsFilePrefix + "*.txt")
+	if err != nil || len(files) == 0 {
+		return "", fmt.Errorf("ERROR: no %s*.txt file found in current directory, nothing to rerun", failedHostsFilePrefix)
+	}
+	sort.Strings(files)
+	return files[len(files)-1], nil
+}
+
+// read the hosts of the failed hosts file, lines with "#" prefix are ignored
+func ReadFailedHostsFile(path string) (map[string]bool, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	hosts := map[string]bool{}
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			hosts[line] = true
+		}
+	}
+	return hosts, nil
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// run in a temporary directory as the current directory, restored by the returned func
+func withTempWorkDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "ssgo-rerun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		os.Chdir(oldDir)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestWriteFailedHostsFile(t *testing.T) {
+	defer withTempWorkDir(t)()
+	if _, err := LatestFailedHostsFile(); err == nil {
+		t.Errorf("LatestFailedHostsFile doesn't fail without any failed hosts file")
+	}
+	hosts := []FailedHost{
+		{"192.168.100.1", "cancelled"},
+		{"192.168.100.2", "failed"},
+		{"192.168.100.3", "success"},
+		{"192.168.100.4", "skipped"},
+		{"192.168.100.2", "failed"},
+		{"web01", "failed"},
+	}
+	path, err := WriteFailedHostsFile(hosts, "ssgo run -i config.ini -g web -c hostname")
+	if err != nil {
+		t.Fatalf("WriteFailedHostsFile failed, %s", err)
+	}
+	if !regexp.MustCompile(`^ssgo-failed-\d{8}-\d{6}\.\d{3}-\d+\.txt$`).MatchString(path) {
+		t.Errorf("failed hosts file %s, want ssgo-failed-<timestamp>-<random>.txt", path)
+	}
+	buf, _ := ioutil.ReadFile(path)
+	want := "# hosts not finished successfully by: ssgo run -i config.ini -g web -c hostname\n" +
+		"# failed\n192.168.100.2\nweb01\n# skipped\n192.168.100.4\n# cancelled\n192.168.100.1\n"
+	if string(buf) != want {
+		t.Errorf("failed hosts file content =\n%s\nwant\n%s", buf, want)
+	}
+	got, err := ReadFailedHostsFile(path)
+	if err != nil {
+		t.Fatalf("ReadFailedHostsFile failed, %s", err)
+	}
+	if want := map[string]bool{"192.168.100.1": true, "192.168.100.2": true, "192.168.100.4": true, "web01": true}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadFailedHostsFile = %v, want %v", got, want)
+	}
+	if latest, err := LatestFailedHostsFile(); err != nil || latest != path {
+		t.Errorf("LatestFailedHostsFile = %s, %v, want %s", latest, err, path)
+	}
+	if second, err := WriteFailedHostsFile(hosts, "ssgo run"); err != nil || second == path {
+		t.Errorf("WriteFailedHostsFile writes %s twice, %v", path, err)
+	}
+}
+
+func TestLatestFailedHostsFile(t *testing.T) {
+	defer withTempWorkDir(t)()
+	for _, name := range []string{
+		"ssgo-failed-20260102-150405.000-123.txt",
+		"ssgo-failed-20260101-150405.999-456.txt",
+		"ssgo-failed-20260102-150405.001-789.txt",
+		"ssgo-failed-20260103-150405.000-1.log",
+	} {
+		if err := ioutil.WriteFile(name, []byte("192.168.100.1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if latest, err := LatestFailedHostsFile(); err != nil || latest != "ssgo-failed-20260102-150405.001-789.txt" {
+		t.Errorf("LatestFailedHostsFile = %s, %v, want ssgo-failed-20260102-150405.001-789.txt", latest, err)
+	}
+}
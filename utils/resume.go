@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+)
+
+// partial files are written with the suffix until complete, see TransferResume
+const PartFileSuffix = ".ssgo-part"
+
+// continue the transfer from the partial file left by the last interrupted transfer,
+// the result is verified by sha256 and the partial file is renamed when complete
+var TransferResume bool
+
+// the size of the remote partial file to continue from, 0 if it doesn't exist or is larger than the source
+func (t *sftpTransfer) remotePartOffset(partPath string, size int64) int64 {
+	info, err := t.sftpClient.Stat(partPath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > size {
+		return 0
+	}
+	return info.Size()
+}
+
+func localPartOffset(partPath string, size int64) int64 {
+	info, err := os.Stat(partPath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > size {
+		return 0
+	}
+	return info.Size()
+}
+
+// compare the sha256 of the local and remote file after the resumed transfer, one of them is the partial file,
+// the partial file is removed if they don't match so the next transfer starts from the beginning
+func (t *sftpTransfer) verifyResumed(localPath, remotePath string, partIsLocal bool) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	digest := hexDigest(hasher)
+	remoteDigest, err := t.remoteSHA256(remotePath)
+	if err != nil {
+		return err
+	}
+	if digest != remoteDigest {
+		if partIsLocal {
+			os.Remove(localPath)
+		} else {
+			t.sftpClient.Remove(remotePath)
+		}
+		return &corruptError{fmt.Sprintf("sha256 mismatch after resuming, %s is %s, remote %s is %s, the partial file is removed", localPath, digest, remotePath, remoteDigest)}
+	}
+	if TransferVerify == "sha256" {
+		t.digests = append(t.digests, fmt.Sprintf("%s  %s", digest, targetPath(remotePath)))
+	}
+	return nil
+}
+
+// rename the remote file, the existing file is replaced atomically by posix-rename@openssh.com
+func (t *sftpTransfer) rename(oldPath, newPath string) error {
+	if _, ok := t.sftpClient.HasExtension("posix-rename@openssh.com"); ok {
+		if err := t.sftpClient.PosixRename(oldPath, newPath); err != nil {
+			return fmt.Errorf("rename %s to %s failed, %s", oldPath, newPath, err)
+		}
+		return nil
+	}
+	// the server doesn't support posix-rename@openssh.com, and rename fails if the file exists,
+	// the existing file is moved aside and moved back if the rename still fails
+	err := t.sftpClient.Rename(oldPath, newPath)
+	if err == nil {
+		return nil
+	}
+	if _, statErr := t.sftpClient.Lstat(newPath); statErr != nil {
+		return fmt.Errorf("rename %s to %s failed, %s", oldPath, newPath, err)
+	}
+	asidePath := tempPath(newPath)
+	if err := t.sftpClient.Rename(newPath, asidePath); err != nil {
+		return fmt.Errorf("rename %s to %s failed, %s", oldPath, newPath, err)
+	}
+	if err := t.sftpClient.Rename(oldPath, newPath); err != nil {
+		t.sftpClient.Rename(asidePath, newPath)
+		return fmt.Errorf("rename %s to %s failed, %s", oldPath, newPath, err)
+	}
+	t.sftpClient.Remove(asidePath)
+	return nil
+}
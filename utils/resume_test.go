@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// a transfer connected to the sftp server in process, which serves the local file system,
+// the sha256 of the remote files is read back by sftp
+func newLocalSFTPTransfer(t *testing.T) (*sftpTransfer, func()) {
+	clientRead, serverWrite := io.Pipe()
+	serverRead, clientWrite := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverRead, serverWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	client, err := sftp.NewClientPipe(clientRead, clientWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &sftpTransfer{ctx: context.Background(), sftpClient: client, noSHA256Sum: true, downloaded: map[string]string{}}
+	// the client waits for the server to close the connection
+	return tr, func() {
+		server.Close()
+		client.Close()
+	}
+}
+
+func TestLocalPartOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssgo-resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	partPath := filepath.Join(dir, "app.tar.gz"+PartFileSuffix)
+	tests := []struct {
+		name string
+		part []byte
+		dir  bool
+		want int64
+	}{
+		{"no partial file", nil, false, 0},
+		{"empty", []byte{}, false, 0},
+		{"half", make([]byte, 50), false, 50},
+		{"complete", make([]byte, 100), false, 100},
+		{"larger than the source", make([]byte, 101), false, 0},
+		{"directory", nil, true, 0},
+	}
+	for _, tt := range tests {
+		os.RemoveAll(partPath)
+		if tt.part != nil {
+			ioutil.WriteFile(partPath, tt.part, 0644)
+		}
+		if tt.dir {
+			os.Mkdir(partPath, 0755)
+		}
+		if got := localPartOffset(partPath, 100); got != tt.want {
+			t.Errorf("%s: localPartOffset = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResumeTransfer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ssgo-resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	sourcePath := filepath.Join(dir, "source.bin")
+	if err := ioutil.WriteFile(sourcePath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append([]byte{}, content[:1000]...)
+	corrupt[10] = 'x'
+	tests := []struct {
+		name        string
+		part        []byte
+		wantBytes   int64
+		wantCorrupt bool
+	}{
+		{"no partial file", nil, int64(len(content)), false},
+		{"half", content[:len(content)/2], int64(len(content) - len(content)/2), false},
+		{"complete", content, 0, false},
+		{"larger than the source", append(append([]byte{}, content...), 'x'), int64(len(content)), false},
+		// the partial file is removed, so the next transfer starts from the beginning
+		{"corrupt", corrupt, int64(len(content) - len(corrupt)), true},
+	}
+	defer func(resume bool) { TransferResume = resume }(TransferResume)
+	TransferResume = true
+	tr, closeTransfer := newLocalSFTPTransfer(t)
+	defer closeTransfer()
+	for _, action := range []string{"upload", "download"} {
+		for _, tt := range tests {
+			targetPath := filepath.Join(dir, action+".bin")
+			partPath := targetPath + PartFileSuffix
+			os.Remove(targetPath)
+			os.Remove(partPath)
+			if tt.part != nil {
+				ioutil.WriteFile(partPath, tt.part, 0644)
+			}
+			tr.bytes = 0
+			if action == "upload" {
+				err = tr.uploadFile(sourcePath, targetPath)
+			} else {
+				err = tr.downloadFile(sourcePath, targetPath)
+			}
+			if tr.bytes != tt.wantBytes && !tt.wantCorrupt {
+				t.Errorf("%s %s: %d bytes transferred, want %d", action, tt.name, tr.bytes, tt.wantBytes)
+			}
+			if tt.wantCorrupt {
+				if err == nil || transferErrorStatus(err) != "corrupt" {
+					t.Errorf("%s %s: error = %v, want corrupt", action, tt.name, err)
+				}
+				if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+					t.Errorf("%s %s: the corrupt partial file is kept", action, tt.name)
+				}
+				if _, err := os.Stat(targetPath); !os.IsNotExist(err) {
+					t.Errorf("%s %s: the corrupt file is renamed to %s", action, tt.name, targetPath)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s %s: failed, %s", action, tt.name, err)
+				continue
+			}
+			if got, _ := ioutil.ReadFile(targetPath); !bytes.Equal(got, content) {
+				t.Errorf("%s %s: the file is not the same as the source", action, tt.name)
+			}
+			if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+				t.Errorf("%s %s: the partial file is kept after complete", action, tt.name)
+			}
+		}
+	}
+}
@@ -1,16 +1,12 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/sftp"
-	"golang.org/x/crypto/ssh"
-	"io"
-	"io/ioutil"
-	"net"
 	"os"
 	"path"
 	"path/filepath"
-	"time"
 )
 
 type SFTPResult struct {
@@ -18,235 +14,203 @@ type SFTPResult struct {
 	Status          string
 	SourcePath      string
 	DestinationPath string
+	Files           int
+	Bytes           int64
+	SkippedFiles    int
+	DeletedFiles    int
+	Digests         string
 	Result          string
 }
 
-// coped from https://github.com/shanghai-edu/multissh (thank you very much)
-func sftpConnect(user, password, host, key string, port int) (*sftp.Client, error) {
-	var (
-		auth         []ssh.AuthMethod
-		addr         string
-		clientConfig *ssh.ClientConfig
-		sshClient    *ssh.Client
-		config       ssh.Config
-		sftpClient   *sftp.Client
-		err          error
-	)
-	// get auth method
-	auth = make([]ssh.AuthMethod, 0)
-	if key == "" {
-		auth = append(auth, ssh.Password(password))
-	} else {
-		pemBytes, err := ioutil.ReadFile(key)
-		if err != nil {
-			return nil, err
+// close the sftp client to interrupt the transfer if the force context of ctx is done(see WithForceContext),
+// call the returned func when the transfer is done
+func closeOnCancel(ctx context.Context, sftpClient *sftp.Client) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ForceContext(ctx).Done():
+			sftpClient.Close()
+		case <-done:
 		}
-
-		var signer ssh.Signer
-		if password == "" {
-			signer, err = ssh.ParsePrivateKey(pemBytes)
-		} else {
-			signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(password))
-		}
-		if err != nil {
-			return nil, err
-		}
-		auth = append(auth, ssh.PublicKeys(signer))
-	}
-
-	clientConfig = &ssh.ClientConfig{
-		User:    user,
-		Auth:    auth,
-		Timeout: 30 * time.Second,
-		Config:  config,
-		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-			return nil
-		},
-	}
-
-	// connect to ssh
-	addr = fmt.Sprintf("%s:%d", host, port)
-
-	if sshClient, err = ssh.Dial("tcp", addr, clientConfig); err != nil {
-		return nil, err
+	}()
+	return func() {
+		close(done)
 	}
+}
 
-	// create sftp client
-	if sftpClient, err = sftp.NewClient(sshClient); err != nil {
-		return nil, err
+// the error message of the interrupted transfer
+func sftpError(ctx context.Context, err error) string {
+	if ForceContext(ctx).Err() != nil {
+		return "cancelled while transferring"
 	}
-
-	return sftpClient, nil
+	return err.Error()
 }
 
-func SFTPSimpleUpload(user, password, host, key string, port int, sourcePath, destinationPath string) SFTPResult {
-	var (
-		err        error
-		sftpClient *sftp.Client
-		sftpResult SFTPResult
-	)
-	sftpResult.Host = host
+func SFTPSimpleUpload(ctx context.Context, h Host, sourcePath, destinationPath string) SFTPResult {
+	var sftpResult SFTPResult
+	sftpResult.Host = h.Address
 	sftpResult.SourcePath = sourcePath
 	sftpResult.DestinationPath = destinationPath
-	sftpClient, err = sftpConnect(user, password, host, key, port)
+	t, err := newSFTPTransfer(ctx, h)
 	if err != nil {
 		sftpResult.Status = "failed"
 		sftpResult.Result = fmt.Sprintf("ERROR: sftp connect to %s failed, error message:%s", sftpResult.Host, err.Error())
 		return sftpResult
 	}
-	defer sftpClient.Close()
-
-	srcFile, err := os.Open(sourcePath)
-	if err != nil {
-		sftpResult.Status = "failed"
-		sftpResult.Result = fmt.Sprintf("ERROR: os open file \"%s\" failed, error message:%s", sftpResult.Host, err.Error())
-		return sftpResult
-	}
-	defer srcFile.Close()
+	defer t.Close()
 
 	if destinationPath == "" {
-		currWorkDir, _ := sftpClient.Getwd()
+		currWorkDir, _ := t.sftpClient.Getwd()
 		sftpResult.DestinationPath = currWorkDir
 	}
-
-	var remoteFileName = filepath.Base(sourcePath)
-	dstFile, err := sftpClient.Create(path.Join(destinationPath, remoteFileName))
+	err = t.uploadFile(sourcePath, path.Join(sftpResult.DestinationPath, filepath.Base(sourcePath)))
+	t.setResult(&sftpResult)
 	if err != nil {
 		sftpResult.Status = "failed"
-		sftpResult.Result = fmt.Sprintf("ERROR: while upload file \"%s\" to remote path \"%s\" ,error message:%s ", sftpResult.SourcePath, sftpResult.DestinationPath, err.Error())
+		sftpResult.Result = fmt.Sprintf("ERROR: while upload file \"%s\" to remote path \"%s\" ,error message:%s ", sftpResult.SourcePath, sftpResult.DestinationPath, sftpError(ctx, err))
 		return sftpResult
 	}
-	defer dstFile.Close()
-
-	buf := make([]byte, 1024)
-	for {
-		n, err := srcFile.Read(buf)
-		if err != nil && err != io.EOF {
-			sftpResult.Status = "failed"
-			sftpResult.Result = fmt.Sprintf("ERROR: while upload file \"%s\" to remote path \"%s\" ,error message:%s ", sftpResult.SourcePath, sftpResult.DestinationPath, err.Error())
-			return sftpResult
-		}
-		if n == 0 {
-			break
-		}
-		dstFile.Write(buf[0:n])
-	}
 
 	sftpResult.Status = "success"
 	sftpResult.Result = fmt.Sprintf("Upload finished!:)")
 	return sftpResult
 }
 
-func SFTPUpload(user, password, host, key string, port int, sourcePath, destinationPath string, chr chan interface{}) {
-	var (
-		err        error
-		sftpClient *sftp.Client
-		sftpResult SFTPResult
-	)
-	sftpResult.Host = host
+func SFTPUpload(ctx context.Context, h Host, sourcePath, destinationPath string, chr chan interface{}) {
+	var sftpResult SFTPResult
+	sftpResult.Host = h.Address
 	sftpResult.SourcePath = sourcePath
 	sftpResult.DestinationPath = destinationPath
-	sftpClient, err = sftpConnect(user, password, host, key, port)
+	t, err := newSFTPTransfer(ctx, h)
 	if err != nil {
 		sftpResult.Status = "failed"
 		sftpResult.Result = fmt.Sprintf("ERROR: sftp connect to %s failed, error message:%s", sftpResult.Host, err.Error())
 		chr <- sftpResult
 		return
 	}
-	defer sftpClient.Close()
+	defer t.Close()
 	if destinationPath == "" {
-		currWorkDir, _ := sftpClient.Getwd()
+		currWorkDir, _ := t.sftpClient.Getwd()
 		sftpResult.DestinationPath = currWorkDir
 	}
-	srcFile, err := os.Open(sourcePath)
+	warning, err := t.uploadGlob(sourcePath, sftpResult.DestinationPath)
+	t.setResult(&sftpResult)
 	if err != nil {
-		sftpResult.Status = "failed"
-		sftpResult.Result = fmt.Sprintf("ERROR: os open file \"%s\" failed, error message:%s", sftpResult.Host, err.Error())
+		sftpResult.Status = transferErrorStatus(err)
+		sftpResult.Result = fmt.Sprintf("ERROR: while upload file \"%s\" to remote path \"%s\" ,error message:%s ", sftpResult.SourcePath, sftpResult.DestinationPath, sftpError(ctx, err))
 		chr <- sftpResult
 		return
 	}
-	defer srcFile.Close()
-	var remoteFileName = filepath.Base(sourcePath)
-	var remoteFilePath = path.Join(destinationPath, remoteFileName)
-	dstFile, err := sftpClient.Create(remoteFilePath)
+
+	sftpResult.Status = "success"
+	sftpResult.Result = fmt.Sprintf("Upload finished!:) %d file(s), %s, %s%s", sftpResult.Files, formatBytes(sftpResult.Bytes), t.throughput(), warning)
+	chr <- sftpResult
+	return
+}
+
+// upload like rsync, unchanged files are skipped and remote files absent locally are deleted by SyncDelete
+func SFTPSync(ctx context.Context, h Host, sourcePath, destinationPath string, chr chan interface{}) {
+	var sftpResult SFTPResult
+	sftpResult.Host = h.Address
+	sftpResult.SourcePath = sourcePath
+	sftpResult.DestinationPath = destinationPath
+	t, err := newSFTPTransfer(ctx, h)
 	if err != nil {
 		sftpResult.Status = "failed"
-		sftpResult.Result = fmt.Sprintf("ERROR: while upload file \"%s\" to remote path \"%s\" ,error message:%s ", sftpResult.SourcePath, sftpResult.DestinationPath, err.Error())
+		sftpResult.Result = fmt.Sprintf("ERROR: sftp connect to %s failed, error message:%s", sftpResult.Host, err.Error())
 		chr <- sftpResult
 		return
 	}
-	defer dstFile.Close()
-
-	buf := make([]byte, 1024)
-	for {
-		n, err := srcFile.Read(buf)
-		if err != nil && err != io.EOF {
-			sftpResult.Status = "failed"
-			sftpResult.Result = fmt.Sprintf("ERROR: while upload file \"%s\" to remote path \"%s\" ,error message:%s ", sftpResult.SourcePath, sftpResult.DestinationPath, err.Error())
-			return
-		}
-		if n == 0 {
-			break
-		}
-		dstFile.Write(buf[0:n])
+	defer t.Close()
+	t.sync = true
+	if destinationPath == "" {
+		currWorkDir, _ := t.sftpClient.Getwd()
+		sftpResult.DestinationPath = currWorkDir
+	}
+	warning, err := t.uploadGlob(sourcePath, sftpResult.DestinationPath)
+	t.setResult(&sftpResult)
+	if err != nil {
+		sftpResult.Status = transferErrorStatus(err)
+		sftpResult.Result = fmt.Sprintf("ERROR: while sync file \"%s\" to remote path \"%s\" ,error message:%s ", sftpResult.SourcePath, sftpResult.DestinationPath, sftpError(ctx, err))
+		chr <- sftpResult
+		return
 	}
 
 	sftpResult.Status = "success"
-	sftpResult.Result = fmt.Sprintf("Upload finished!:)")
+	sftpResult.Result = fmt.Sprintf("Sync finished!:) %d file(s) transferred, %s, %s, %d unchanged file(s) skipped, %d file(s) deleted%s", sftpResult.Files, formatBytes(sftpResult.Bytes), t.throughput(), sftpResult.SkippedFiles, sftpResult.DeletedFiles, warning)
 	chr <- sftpResult
 	return
 }
 
-func SFTPDownload(user, password, host, key string, port int, sourcePath, destinationPath string, chr chan interface{}) {
-	var (
-		err        error
-		sftpClient *sftp.Client
-		sftpResult SFTPResult
-	)
-	sftpResult.Host = host
+// restore the remote files of the upload from their latest backups, see TransferBackup
+func SFTPRollback(ctx context.Context, h Host, sourcePath, destinationPath string, chr chan interface{}) {
+	var sftpResult SFTPResult
+	sftpResult.Host = h.Address
 	sftpResult.SourcePath = sourcePath
 	sftpResult.DestinationPath = destinationPath
-	sftpClient, err = sftpConnect(user, password, host, key, port)
+	t, err := newSFTPTransfer(ctx, h)
 	if err != nil {
 		sftpResult.Status = "failed"
 		sftpResult.Result = fmt.Sprintf("ERROR: sftp connect to %s failed, error message:%s", sftpResult.Host, err.Error())
 		chr <- sftpResult
 		return
 	}
-	defer sftpClient.Close()
-
-	srcFile, err := sftpClient.Open(sourcePath)
+	defer t.Close()
+	t.rollback = true
+	if destinationPath == "" {
+		currWorkDir, _ := t.sftpClient.Getwd()
+		sftpResult.DestinationPath = currWorkDir
+	}
+	warning, err := t.uploadGlob(sourcePath, sftpResult.DestinationPath)
+	t.setResult(&sftpResult)
 	if err != nil {
 		sftpResult.Status = "failed"
-		sftpResult.Result = fmt.Sprintf("ERROR: sftp open file failed %s, error message:%s", sftpResult.SourcePath, err.Error())
+		sftpResult.Result = fmt.Sprintf("ERROR: while rollback file \"%s\" in remote path \"%s\" ,error message:%s ", sftpResult.SourcePath, sftpResult.DestinationPath, sftpError(ctx, err))
 		chr <- sftpResult
 		return
 	}
-	defer srcFile.Close()
-	if destinationPath == "" {
-		currWorkDir, _ := os.Getwd()
-		sftpResult.DestinationPath = currWorkDir
+	if sftpResult.Files == 0 {
+		sftpResult.Status = "failed"
+		sftpResult.Result = fmt.Sprintf("ERROR: no backup of \"%s\" found in remote path \"%s\"%s", sftpResult.SourcePath, sftpResult.DestinationPath, warning)
+		chr <- sftpResult
+		return
 	}
-	fileInfo, _ := srcFile.Stat()
-	var localFileName = fmt.Sprintf("%s_%s", sftpResult.Host, fileInfo.Name())
-	dstFile, err := os.Create(filepath.Join(destinationPath, localFileName))
+
+	sftpResult.Status = "success"
+	sftpResult.Result = fmt.Sprintf("Rollback finished!:) %d file(s) restored, %d file(s) without backup skipped%s", sftpResult.Files, sftpResult.SkippedFiles, warning)
+	chr <- sftpResult
+	return
+}
+
+func SFTPDownload(ctx context.Context, h Host, sourcePath, destinationPath string, chr chan interface{}) {
+	var sftpResult SFTPResult
+	sftpResult.Host = h.Address
+	sftpResult.SourcePath = sourcePath
+	sftpResult.DestinationPath = destinationPath
+	t, err := newSFTPTransfer(ctx, h)
 	if err != nil {
 		sftpResult.Status = "failed"
-		sftpResult.Result = fmt.Sprintf("ERROR: while download file \"%s\" to local path \"%s\" ,error message: %s", sftpResult.SourcePath, sftpResult.DestinationPath, err.Error())
+		sftpResult.Result = fmt.Sprintf("ERROR: sftp connect to %s failed, error message:%s", sftpResult.Host, err.Error())
 		chr <- sftpResult
 		return
 	}
-	defer dstFile.Close()
+	defer t.Close()
 
-	if _, err := srcFile.WriteTo(dstFile); err != nil {
-		sftpResult.Status = "failed"
-		sftpResult.Result = fmt.Sprintf("ERROR: while download file \"%s\" to local path \"%s\" ,error message:%s ", sftpResult.SourcePath, sftpResult.DestinationPath, err.Error())
+	if destinationPath == "" {
+		currWorkDir, _ := os.Getwd()
+		sftpResult.DestinationPath = currWorkDir
+	}
+	warning, err := t.downloadGlob(sourcePath, sftpResult.DestinationPath)
+	t.setResult(&sftpResult)
+	if err != nil {
+		sftpResult.Status = transferErrorStatus(err)
+		sftpResult.Result = fmt.Sprintf("ERROR: while download file \"%s\" to local path \"%s\" ,error message:%s ", sftpResult.SourcePath, sftpResult.DestinationPath, sftpError(ctx, err))
 		chr <- sftpResult
 		return
 	}
 
 	sftpResult.Status = "success"
-	sftpResult.Result = fmt.Sprintf("Download finished!:)")
+	sftpResult.Result = fmt.Sprintf("Download finished!:) %d file(s), %s, %s%s", sftpResult.Files, formatBytes(sftpResult.Bytes), t.throughput(), warning)
 	chr <- sftpResult
 	return
 }
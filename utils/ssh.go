@@ -2,11 +2,13 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"golang.org/x/crypto/ssh"
 	"io/ioutil"
 	"net"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -17,53 +19,188 @@ type SSHResult struct {
 	Result string
 }
 
+// passphrase of the encrypted private key file, prompted by --ask-key-passphrase and kept in memory only
+var KeyPassphrase []byte
+
+// retry connecting the host on connection-level failures, e.g. timeout, connection refused or reset,
+// the delay is doubled after each retry
+var (
+	ConnectRetries    int
+	ConnectRetryDelay = 5 * time.Second
+)
+
 // coped from https://github.com/shanghai-edu/multissh (thank you very much)
-func connect(user, password, host, key string, port int) (*ssh.Session, error) {
-	var (
-		auth         []ssh.AuthMethod
-		addr         string
-		clientConfig *ssh.ClientConfig
-		client       *ssh.Client
-		config       ssh.Config
-		session      *ssh.Session
-		err          error
-	)
-	// get auth method
-	auth = make([]ssh.AuthMethod, 0)
-	if key == "" {
-		auth = append(auth, ssh.Password(password))
-	} else {
+// get ssh auth method by password or private key file
+func sshAuthMethods(password, key string) ([]ssh.AuthMethod, error) {
+	auth := make([]ssh.AuthMethod, 0)
+	if key != "" {
 		pemBytes, err := ioutil.ReadFile(key)
 		if err != nil {
 			return nil, err
 		}
-
-		var signer ssh.Signer
-		if password == "" {
-			signer, err = ssh.ParsePrivateKey(pemBytes)
-		} else {
-			signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(password))
+		// the key is usually not encrypted, the password(e.g. inherited from [vars]) is tried as the passphrase
+		// only if it's encrypted and --ask-key-passphrase is not specified
+		signer, err := ssh.ParsePrivateKey(pemBytes)
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			passphrase := KeyPassphrase
+			if len(passphrase) == 0 {
+				passphrase = []byte(password)
+			}
+			if len(passphrase) == 0 {
+				return nil, fmt.Errorf("private key %s is encrypted, please specify the passphrase by --ask-key-passphrase", key)
+			}
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, passphrase)
 		}
 		if err != nil {
 			return nil, err
 		}
 		auth = append(auth, ssh.PublicKeys(signer))
 	}
+	if key == "" || password != "" {
+		auth = append(auth, ssh.Password(password))
+		// some servers only accept keyboard-interactive auth, answer the password to every question
+		auth = append(auth, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range questions {
+				answers[i] = password
+			}
+			return answers, nil
+		}))
+	}
+	return auth, nil
+}
 
-	clientConfig = &ssh.ClientConfig{
-		User:    user,
+// parse jump host like "192.168.100.1", "admin@192.168.100.1" or "admin@192.168.100.1:2222",
+// login user is the same as the target host and port is defaultPort if not specified
+func parseJumpHost(jump, defaultUser string, defaultPort int) (string, string, error) {
+	jumpUser := defaultUser
+	if i := strings.LastIndex(jump, "@"); i > -1 {
+		jumpUser = jump[:i]
+		jump = jump[i+1:]
+	}
+	if _, _, err := net.SplitHostPort(jump); err != nil {
+		jump = net.JoinHostPort(jump, strconv.Itoa(defaultPort))
+	}
+	if jumpUser == "" || strings.HasPrefix(jump, ":") {
+		return "", "", fmt.Errorf("invalid jump host '%s', e.g. admin@192.168.100.1:22", jump)
+	}
+	return jumpUser, jump, nil
+}
+
+// dial the remote host directly, or through the jump host if specified
+func sshDial(h Host, timeout time.Duration) (*ssh.Client, error) {
+	var config ssh.Config
+	auth, err := sshAuthMethods(h.Password, h.Key)
+	if err != nil {
+		return nil, err
+	}
+	clientConfig := &ssh.ClientConfig{
+		User:    h.User,
 		Auth:    auth,
-		Timeout: 5 * time.Second,
+		Timeout: timeout,
 		Config:  config,
 		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
 			return nil
 		},
 	}
+	addr := net.JoinHostPort(h.Address, strconv.Itoa(h.Port))
+	if h.Jump == "" {
+		return ssh.Dial("tcp", addr, clientConfig)
+	}
 
-	// connect to ssh
-	addr = fmt.Sprintf("%s:%d", host, port)
+	// the jump host listens on the default ssh port usually, even if the target host doesn't
+	jumpUser, jumpAddr, err := parseJumpHost(h.Jump, h.User, 22)
+	if err != nil {
+		return nil, err
+	}
+	jumpConfig := *clientConfig
+	jumpConfig.User = jumpUser
+	jumpClient, err := ssh.Dial("tcp", jumpAddr, &jumpConfig)
+	if err != nil {
+		return nil, fmt.Errorf("connect to jump host %s failed, %s", jumpAddr, err)
+	}
+	conn, err := jumpClient.Dial("tcp", addr)
+	if err != nil {
+		jumpClient.Close()
+		return nil, fmt.Errorf("dial %s from jump host %s failed, %s", addr, jumpAddr, err)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if err != nil {
+		conn.Close()
+		jumpClient.Close()
+		return nil, err
+	}
+	return ssh.NewClient(&jumpConn{Conn: c, jumpClient: jumpClient}, chans, reqs), nil
+}
+
+// the connection to the target host through the jump host, the jump host is closed with it
+type jumpConn struct {
+	ssh.Conn
+	jumpClient *ssh.Client
+}
+
+func (c *jumpConn) Close() error {
+	err := c.Conn.Close()
+	c.jumpClient.Close()
+	return err
+}
+
+// check if the error is a connection-level failure which may be transient,
+// authentication and private key errors are not retried
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "unable to authenticate") || strings.Contains(msg, "private key") {
+		return false
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	for _, s := range []string{"connection refused", "connection reset", "no route to host", "network is unreachable", "i/o timeout", "handshake failed", "EOF"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// wait before the retry, the delay is doubled after each retry, returns false if ctx is done
+func WaitForRetry(ctx context.Context, delay time.Duration, retry int) bool {
+	if retry > 10 {
+		retry = 10
+	}
+	select {
+	case <-time.After(delay << uint(retry)):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
 
-	if client, err = ssh.Dial("tcp", addr, clientConfig); err != nil {
+// dial the remote host and retry on connection-level failures by ConnectRetries
+func sshDialWithRetry(ctx context.Context, h Host, timeout time.Duration) (*ssh.Client, error) {
+	client, err := sshDial(h, timeout)
+	for retry := 0; retry < ConnectRetries && IsConnectionError(err); retry++ {
+		if !WaitForRetry(ctx, ConnectRetryDelay, retry) {
+			return nil, fmt.Errorf("%s, cancelled before retrying", err)
+		}
+		if client, err = sshDial(h, timeout); err != nil && retry == ConnectRetries-1 {
+			err = fmt.Errorf("%s, failed after %d retries", err, ConnectRetries)
+		}
+	}
+	return client, err
+}
+
+func connect(ctx context.Context, h Host) (*ssh.Session, error) {
+	var (
+		client  *ssh.Client
+		session *ssh.Session
+		err     error
+	)
+	// connect to ssh
+	if client, err = sshDialWithRetry(ctx, h, 5*time.Second); err != nil {
 		return nil, err
 	}
 
@@ -85,11 +222,56 @@ func connect(user, password, host, key string, port int) (*ssh.Session, error) {
 	return session, nil
 }
 
-func SSHRunShellScript(user, password, host, key, scriptFilePath, scriptArgs string, port int, chr chan interface{}) {
+type forceContextKey struct{}
+
+// ctx is done to stop the running commands gracefully, e.g. by the first Ctrl-C,
+// and force is done to close the connections immediately, e.g. by the second Ctrl-C or --abort-running
+func WithForceContext(ctx, force context.Context) context.Context {
+	return context.WithValue(ctx, forceContextKey{}, force)
+}
+
+// get the force context of ctx, it's ctx itself if not set
+func ForceContext(ctx context.Context) context.Context {
+	if force, ok := ctx.Value(forceContextKey{}).(context.Context); ok {
+		return force
+	}
+	return ctx
+}
+
+// run the command in the session, SIGINT is sent to the remote command if ctx is done,
+// and the session is closed if the force context is done
+func runSession(ctx context.Context, session *ssh.Session, cmd string) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("cancelled before running")
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(cmd)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+	session.Signal(ssh.SIGINT)
+	select {
+	case err := <-done:
+		if err == nil {
+			return nil
+		}
+		return fmt.Errorf("interrupted while running, %s", err)
+	case <-ForceContext(ctx).Done():
+		session.Close()
+		<-done
+		return fmt.Errorf("cancelled while running")
+	}
+}
+
+func SSHRunShellScript(ctx context.Context, h Host, scriptFilePath, scriptArgs string, chr chan interface{}) {
 	var sshResult SSHResult
 	var cmds []string
-	sshResult.Host = host
-	session, err := connect(user, password, host, key, port)
+	sshResult.Host = h.Address
+	session, err := connect(ctx, h)
 	if err != nil {
 		sshResult.Status = "failed"
 		sshResult.Result = fmt.Sprintf("ERROR: while connecting host %s, an error occured,error message: %s", sshResult.Host, err)
@@ -101,10 +283,10 @@ func SSHRunShellScript(user, password, host, key, scriptFilePath, scriptArgs str
 	session.Stdout = &outBuffer
 	session.Stderr = &errBuffer
 
-	resSftpResult := SFTPSimpleUpload(user, password, host, key, port, scriptFilePath, "")
-	if resSftpResult.Status == "false" {
+	resSftpResult := SFTPSimpleUpload(ctx, h, scriptFilePath, "")
+	if resSftpResult.Status == "failed" {
 		sshResult.Status = "failed"
-		sshResult.Result = fmt.Sprintf("ERROR: copy local Shell script %s to host %s failed, error message: %s", scriptFilePath, sshResult.Host, err.Error())
+		sshResult.Result = fmt.Sprintf("ERROR: copy local Shell script %s to host %s failed, error message: %s", scriptFilePath, sshResult.Host, resSftpResult.Result)
 		chr <- sshResult
 		return
 	}
@@ -115,7 +297,14 @@ func SSHRunShellScript(user, password, host, key, scriptFilePath, scriptArgs str
 	removeScriptBeforeExitCmd := fmt.Sprintf("rm -rf %s", scriptFileRemotePath)
 	cmds = append(cmds, executeScriptCmd, removeScriptBeforeExitCmd, "exit")
 	cmd := strings.Join(cmds, " && ")
-	err = session.Run(cmd)
+	err = runSession(ctx, session, cmd)
+	if err != nil && ctx.Err() != nil {
+		// the script is left on the remote host if it's interrupted, remove it by a new session
+		if cleanSession, err := connect(ForceContext(ctx), h); err == nil {
+			cleanSession.Run(removeScriptBeforeExitCmd)
+			cleanSession.Close()
+		}
+	}
 	if err != nil {
 		sshResult.Status = "failed"
 		res := outBuffer.String()
@@ -133,13 +322,12 @@ func SSHRunShellScript(user, password, host, key, scriptFilePath, scriptArgs str
 		sshResult.Result = outBuffer.String()
 		chr <- sshResult
 	}
-	chr <- sshResult
 	return
 }
-func DoSSHRunFast(user, password, host, key string, cmdList []string, port int, chr chan interface{}) {
+func DoSSHRunFast(ctx context.Context, h Host, cmdList []string, chr chan interface{}) {
 	var sshResult SSHResult
-	sshResult.Host = host
-	session, err := connect(user, password, host, key, port)
+	sshResult.Host = h.Address
+	session, err := connect(ctx, h)
 	if err != nil {
 		sshResult.Status = "failed"
 		sshResult.Result = fmt.Sprintf("ERROR: while connecting host %s, an error occured %s", sshResult.Host, err)
@@ -153,7 +341,7 @@ func DoSSHRunFast(user, password, host, key string, cmdList []string, port int,
 	session.Stderr = &errBuffer
 
 	newCmd := strings.Join(cmdList, " && ")
-	err = session.Run(newCmd)
+	err = runSession(ctx, session, newCmd)
 	if err != nil {
 		sshResult.Status = "failed"
 		res := outBuffer.String()
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// compare files by sha256 instead of size and modification time for the sync action
+var SyncChecksum bool
+
+// delete remote files absent locally for the sync action
+var SyncDelete bool
+
+// compare the remote file with the local file for the sync action, "changed" if the remote file isn't a regular
+// file of the same size, otherwise "unchanged" if the modification time is the same too, or "checksum" if their
+// sha256 are compared by SyncChecksum
+func syncCompare(localInfo, remoteInfo os.FileInfo) string {
+	if !remoteInfo.Mode().IsRegular() || remoteInfo.Size() != localInfo.Size() {
+		return "changed"
+	}
+	if SyncChecksum {
+		return "checksum"
+	}
+	// sftp keeps the modification time in seconds
+	if remoteInfo.ModTime().Unix() != localInfo.ModTime().Unix() {
+		return "changed"
+	}
+	return "unchanged"
+}
+
+// check if the remote file is the same as the local file by size and modification time, or sha256 by SyncChecksum
+func (t *sftpTransfer) unchanged(localPath string, localInfo os.FileInfo, remotePath string) (bool, error) {
+	remoteInfo, err := t.sftpClient.Stat(remotePath)
+	if err != nil {
+		return false, nil
+	}
+	if result := syncCompare(localInfo, remoteInfo); result != "checksum" {
+		return result == "unchanged", nil
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return false, err
+	}
+	remoteDigest, err := t.remoteSHA256(remotePath)
+	if err != nil {
+		return false, err
+	}
+	return hexDigest(hasher) == remoteDigest, nil
+}
+
+// a remote file or directory found by walking the remote root
+type remoteEntry struct {
+	path  string
+	isDir bool
+}
+
+// the remote files and directories to delete by SyncDelete, which are not in localFiles, entries are in walk order,
+// the files in an absent directory are deleted with it, so they are not listed
+func absentPaths(entries []remoteEntry, localFiles map[string]bool) []string {
+	var absent []string
+	absentDir := ""
+	for _, e := range entries {
+		if absentDir != "" && strings.HasPrefix(e.path, absentDir+"/") {
+			continue
+		}
+		// the backups, partial and temporary files of ssgo are not the synced files
+		if localFiles[e.path] || isTransferFile(e.path) {
+			continue
+		}
+		absent = append(absent, e.path)
+		if e.isDir {
+			absentDir = e.path
+		}
+	}
+	return absent
+}
+
+// delete the files and directories under the remote root which are not in localFiles
+func (t *sftpTransfer) deleteAbsent(root string, localFiles map[string]bool) error {
+	var entries []remoteEntry
+	walker := t.sftpClient.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+		entries = append(entries, remoteEntry{path: walker.Path(), isDir: walker.Stat().IsDir()})
+	}
+	for _, remotePath := range absentPaths(entries, localFiles) {
+		if t.ctx.Err() != nil {
+			return fmt.Errorf("interrupted after %d file(s) deleted", t.deletedFiles)
+		}
+		if err := t.removeAll(remotePath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove the remote file, or the remote directory and all files in it
+func (t *sftpTransfer) removeAll(remotePath string) error {
+	info, err := t.sftpClient.Lstat(remotePath)
+	if err != nil {
+		return fmt.Errorf("delete %s failed, %s", remotePath, err)
+	}
+	if info.IsDir() {
+		entries, err := t.sftpClient.ReadDir(remotePath)
+		if err != nil {
+			return fmt.Errorf("delete %s failed, %s", remotePath, err)
+		}
+		for _, e := range entries {
+			if err := t.removeAll(remotePath + "/" + e.Name()); err != nil {
+				return err
+			}
+		}
+		if err := t.sftpClient.RemoveDirectory(remotePath); err != nil {
+			return fmt.Errorf("delete %s failed, %s", remotePath, err)
+		}
+		return nil
+	}
+	if err := t.sftpClient.Remove(remotePath); err != nil {
+		return fmt.Errorf("delete %s failed, %s", remotePath, err)
+	}
+	t.deletedFiles++
+	return nil
+}
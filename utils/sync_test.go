@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// the file info of the sync tests
+type syncFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi syncFileInfo) Name() string       { return fi.name }
+func (fi syncFileInfo) Size() int64        { return fi.size }
+func (fi syncFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi syncFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi syncFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi syncFileInfo) Sys() interface{}   { return nil }
+
+func TestSyncCompare(t *testing.T) {
+	modTime := time.Date(2026, 10, 19, 18, 0, 0, 500, time.Local)
+	local := syncFileInfo{"app.conf", 100, 0644, modTime}
+	tests := []struct {
+		name     string
+		remote   syncFileInfo
+		checksum bool
+		want     string
+	}{
+		{"same", syncFileInfo{"app.conf", 100, 0600, modTime.Truncate(time.Second)}, false, "unchanged"},
+		{"newer", syncFileInfo{"app.conf", 100, 0644, modTime.Add(time.Second)}, false, "changed"},
+		{"size", syncFileInfo{"app.conf", 99, 0644, modTime}, false, "changed"},
+		{"directory", syncFileInfo{"app.conf", 100, os.ModeDir | 0755, modTime}, false, "changed"},
+		{"symlink", syncFileInfo{"app.conf", 100, os.ModeSymlink | 0777, modTime}, false, "changed"},
+		{"same by checksum", syncFileInfo{"app.conf", 100, 0644, modTime}, true, "checksum"},
+		{"newer by checksum", syncFileInfo{"app.conf", 100, 0644, modTime.Add(time.Hour)}, true, "checksum"},
+		{"size by checksum", syncFileInfo{"app.conf", 99, 0644, modTime}, true, "changed"},
+	}
+	defer func(checksum bool) { SyncChecksum = checksum }(SyncChecksum)
+	for _, tt := range tests {
+		SyncChecksum = tt.checksum
+		if got := syncCompare(local, tt.remote); got != tt.want {
+			t.Errorf("%s: syncCompare = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAbsentPaths(t *testing.T) {
+	entries := []remoteEntry{
+		{"/opt/app", true},
+		{"/opt/app/bin", true},
+		{"/opt/app/bin/app", false},
+		{"/opt/app/bin/old", false},
+		{"/opt/app/logs", true},
+		{"/opt/app/logs/a.log", false},
+		{"/opt/app/logs/old", true},
+		{"/opt/app/logs/old/b.log", false},
+		{"/opt/app/logs2", true},
+		{"/opt/app/logs2/c.log", false},
+		{"/opt/app/run.sh", false},
+	}
+	tests := []struct {
+		name       string
+		localFiles []string
+		want       []string
+	}{
+		{"all local", []string{"/opt/app", "/opt/app/bin", "/opt/app/bin/app", "/opt/app/bin/old", "/opt/app/logs", "/opt/app/logs/a.log",
+			"/opt/app/logs/old", "/opt/app/logs/old/b.log", "/opt/app/logs2", "/opt/app/logs2/c.log", "/opt/app/run.sh"}, nil},
+		// the absent directory is deleted with the files in it, the directory with a similar name is not in it
+		{"absent directory", []string{"/opt/app", "/opt/app/bin", "/opt/app/bin/app", "/opt/app/logs2", "/opt/app/run.sh"},
+			[]string{"/opt/app/bin/old", "/opt/app/logs", "/opt/app/logs2/c.log"}},
+		{"nested absent directory", []string{"/opt/app", "/opt/app/bin", "/opt/app/bin/app", "/opt/app/bin/old", "/opt/app/logs", "/opt/app/logs/a.log",
+			"/opt/app/logs2", "/opt/app/logs2/c.log"}, []string{"/opt/app/logs/old", "/opt/app/run.sh"}},
+		{"local file only", []string{"/opt/app"}, []string{"/opt/app/bin", "/opt/app/logs", "/opt/app/logs2", "/opt/app/run.sh"}},
+	}
+	// the backups, partial and temporary files of ssgo are kept
+	entries = append(entries, remoteEntry{"/opt/app/run.sh.20261019-180000.bak", false}, remoteEntry{"/opt/app/run.sh" + PartFileSuffix, false},
+		remoteEntry{tempPath("/opt/app/run.sh"), false})
+	for _, tt := range tests {
+		localFiles := map[string]bool{}
+		for _, p := range tt.localFiles {
+			localFiles[p] = true
+		}
+		if got := absentPaths(entries, localFiles); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: absentPaths = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the bandwidth limit of each host in bytes per second, 0 means no limit
+var BandwidthLimit int64
+
+// the bandwidth limit of all hosts in bytes per second, 0 means no limit
+var TotalBandwidthLimit int64
+
+var (
+	totalLimiter     *rateLimiter
+	totalLimiterOnce sync.Once
+)
+
+// the limited stream is read and written in small chunks, so the limit is smooth
+const limitedChunkSize = 32 * 1024
+
+// parse the bandwidth like 512K, 10M or 1G(bytes per second), the "B" and "/s" suffixes are optional
+func ParseBandwidth(s string) (int64, error) {
+	v := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "/S"), "B")
+	unit := int64(1)
+	if i := strings.IndexAny(v, "KMG"); i >= 0 && i == len(v)-1 {
+		unit = map[byte]int64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30}[v[i]]
+		v = v[:i]
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("ERROR: invalid bandwidth \"%s\", e.g. 512K, 10M or 1G bytes per second", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// limit the transferred bytes per second, the bytes are paced instead of sent in bursts
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64
+	// when the next bytes can be sent
+	next time.Time
+}
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(bytesPerSecond)}
+}
+
+// wait until n bytes can be sent, it's interrupted by the force context of ctx(see WithForceContext)
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ForceContext(ctx).Done():
+		return ForceContext(ctx).Err()
+	}
+}
+
+// the limiters of the host by BandwidthLimit and TotalBandwidthLimit
+func newRateLimiters() []*rateLimiter {
+	totalLimiterOnce.Do(func() {
+		totalLimiter = newRateLimiter(TotalBandwidthLimit)
+	})
+	var limiters []*rateLimiter
+	for _, l := range []*rateLimiter{newRateLimiter(BandwidthLimit), totalLimiter} {
+		if l != nil {
+			limiters = append(limiters, l)
+		}
+	}
+	return limiters
+}
+
+func (t *sftpTransfer) waitLimiters(n int) error {
+	for _, l := range t.limiters {
+		if err := l.wait(t.ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the local stream of the uploaded file, it's hashed by hasher(if not nil) and limited by the limiters of the host,
+// Size lets sftp write the file with concurrent requests
+type uploadReader struct {
+	t      *sftpTransfer
+	r      io.Reader
+	size   int64
+	hasher hash.Hash
+}
+
+func (t *sftpTransfer) newUploadReader(r io.Reader, size int64, hasher hash.Hash) *uploadReader {
+	return &uploadReader{t: t, r: r, size: size, hasher: hasher}
+}
+
+func (r *uploadReader) Size() int64 {
+	return r.size
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	if len(r.t.limiters) > 0 && len(p) > limitedChunkSize {
+		p = p[:limitedChunkSize]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if r.hasher != nil {
+			r.hasher.Write(p[:n])
+		}
+		if waitErr := r.t.waitLimiters(n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// the local file of the downloaded file, the received stream is hashed and limited like uploadReader
+type downloadWriter struct {
+	t      *sftpTransfer
+	w      io.Writer
+	hasher hash.Hash
+}
+
+func (t *sftpTransfer) newDownloadWriter(w io.Writer, hasher hash.Hash) *downloadWriter {
+	return &downloadWriter{t: t, w: w, hasher: hasher}
+}
+
+func (w *downloadWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(w.t.limiters) > 0 && len(chunk) > limitedChunkSize {
+			chunk = chunk[:limitedChunkSize]
+		}
+		if err := w.t.waitLimiters(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		if w.hasher != nil {
+			w.hasher.Write(chunk[:n])
+		}
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// the average throughput of the host since connected, e.g. 12.5MB/s
+func (t *sftpTransfer) throughput() string {
+	elapsed := time.Since(t.start).Seconds()
+	if elapsed <= 0 {
+		return "0B/s"
+	}
+	return formatBytes(int64(float64(t.bytes)/elapsed)) + "/s"
+}
@@ -0,0 +1,36 @@
+package utils
+
+import "testing"
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		err  bool
+	}{
+		{"512", 512, false},
+		{"512K", 512 << 10, false},
+		{"10M", 10 << 20, false},
+		{"1G", 1 << 30, false},
+		{"1.5M", 3 << 19, false},
+		{"10MB", 10 << 20, false},
+		{"10mb/s", 10 << 20, false},
+		{" 100K ", 100 << 10, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"abc", 0, true},
+		{"-1M", 0, true},
+		{"10MK", 0, true},
+		{"K10", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBandwidth(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseBandwidth(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseBandwidth(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// the file transfer of one host, the ssh client is also used to run commands like sha256sum on the remote host
+type sftpTransfer struct {
+	ctx        context.Context
+	host       string
+	user       string
+	port       int
+	group      string
+	sshClient  *ssh.Client
+	sftpClient *sftp.Client
+	stopCancel func()
+
+	// upload like rsync, see SFTPSync
+	sync bool
+	// restore the remote files from their latest backups instead of uploading, see SFTPRollback
+	rollback bool
+
+	files        int
+	bytes        int64
+	skippedFiles int
+	deletedFiles int
+	digests      []string
+	// sha256sum is not available on the remote host, read the file back by sftp instead
+	noSHA256Sum bool
+	// the uid and gid of TransferOwner and TransferGroup on the remote host, see remoteOwnerIDs
+	ownerIDs []int
+	// the local paths of the downloaded files and their remote paths, see DownloadLayout
+	downloaded map[string]string
+	// the limiters of BandwidthLimit and TotalBandwidthLimit
+	limiters []*rateLimiter
+	start    time.Time
+}
+
+func newSFTPTransfer(ctx context.Context, h Host) (*sftpTransfer, error) {
+	sshClient, err := sshDialWithRetry(ctx, h, 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	// files are written and read with concurrent requests of 32KB packets, the largest packet size all servers support
+	sftpClient, err := sftp.NewClient(sshClient, sftp.UseConcurrentWrites(true), sftp.MaxConcurrentRequestsPerFile(64))
+	if err != nil {
+		sshClient.Close()
+		return nil, err
+	}
+	t := &sftpTransfer{ctx: ctx, host: h.Address, user: h.User, port: h.Port, group: h.Group, sshClient: sshClient, sftpClient: sftpClient, downloaded: map[string]string{}, limiters: newRateLimiters(), start: time.Now()}
+	t.stopCancel = closeOnCancel(ctx, sftpClient)
+	return t, nil
+}
+
+func (t *sftpTransfer) Close() {
+	t.stopCancel()
+	t.sftpClient.Close()
+	t.sshClient.Close()
+}
+
+// copy the number of files, bytes and digests into the result
+func (t *sftpTransfer) setResult(res *SFTPResult) {
+	res.Files = t.files
+	res.Bytes = t.bytes
+	res.SkippedFiles = t.skippedFiles
+	res.DeletedFiles = t.deletedFiles
+	res.Digests = strings.Join(t.digests, "\n")
+}
+
+// check if the source path is a shell-style glob pattern, e.g. /var/log/app/*.log
+func isGlobPattern(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// upload every local file or directory matching the glob pattern, a pattern matching nothing
+// is a warning instead of a failure
+func (t *sftpTransfer) uploadGlob(pattern, remoteDir string) (string, error) {
+	matches := []string{pattern}
+	if isGlobPattern(pattern) {
+		var err error
+		if matches, err = filepath.Glob(pattern); err != nil {
+			return "", fmt.Errorf("invalid pattern %s, %s", pattern, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Sprintf("\nWARNING: no local file matches %s", pattern), nil
+		}
+	}
+	for _, localPath := range matches {
+		if err := t.uploadPath(localPath, remoteDir); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// download every remote file or directory matching the glob pattern, a pattern matching nothing
+// is a warning instead of a failure
+func (t *sftpTransfer) downloadGlob(pattern, localDir string) (string, error) {
+	matches := []string{pattern}
+	if isGlobPattern(pattern) {
+		var err error
+		if matches, err = t.sftpClient.Glob(pattern); err != nil {
+			return "", fmt.Errorf("invalid pattern %s, %s", pattern, err)
+		}
+		if len(matches) == 0 {
+			return fmt.Sprintf("\nWARNING: no remote file matches %s on host %s", pattern, t.host), nil
+		}
+		// matches are saved as "<host>_<name>" by the prefix layout, they would overwrite each other if the names are the same
+		if DownloadLayout == "prefix" {
+			names := map[string]string{}
+			for _, m := range matches {
+				if other, ok := names[path.Base(m)]; ok {
+					return "", fmt.Errorf("%s and %s have the same name, they can't be downloaded into one directory, try --layout dir", other, m)
+				}
+				names[path.Base(m)] = m
+			}
+		}
+	}
+	for _, remotePath := range matches {
+		if err := t.downloadPath(remotePath, localDir); err != nil {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// upload the local file or directory into the remote directory, directories are uploaded recursively,
+// missing remote directories are created
+func (t *sftpTransfer) uploadPath(localPath, remoteDir string) error {
+	localPath = filepath.Clean(localPath)
+	root := path.Join(remoteDir, filepath.Base(localPath))
+	// remote paths of the local files, the others are deleted by SyncDelete
+	localFiles := map[string]bool{}
+	err := filepath.Walk(localPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if t.ctx.Err() != nil {
+			return fmt.Errorf("interrupted after %d file(s) uploaded", t.files)
+		}
+		rel, err := filepath.Rel(localPath, p)
+		if err != nil {
+			return err
+		}
+		remotePath := path.Join(root, filepath.ToSlash(rel))
+		localFiles[remotePath] = true
+		if t.rollback {
+			if info.Mode().IsRegular() {
+				return t.restoreBackup(remotePath)
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if err := t.sftpClient.MkdirAll(remotePath); err != nil {
+				return fmt.Errorf("create remote directory %s failed, %s", remotePath, err)
+			}
+			return nil
+		}
+		// symlinks, sockets and devices are not transferred
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if err := t.sftpClient.MkdirAll(path.Dir(remotePath)); err != nil {
+			return fmt.Errorf("create remote directory %s failed, %s", path.Dir(remotePath), err)
+		}
+		return t.uploadFile(p, remotePath)
+	})
+	if err != nil || !t.sync || !SyncDelete {
+		return err
+	}
+	if info, err := os.Stat(localPath); err != nil || !info.IsDir() {
+		return err
+	}
+	return t.deleteAbsent(root, localFiles)
+}
+
+func (t *sftpTransfer) uploadFile(localPath, remotePath string) error {
+	remotePath, err := t.resolveLink(remotePath)
+	if err != nil {
+		return err
+	}
+	srcFile, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+	localInfo, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+	if t.sync {
+		unchanged, err := t.unchanged(localPath, localInfo, remotePath)
+		if err != nil {
+			return err
+		}
+		if unchanged {
+			t.skippedFiles++
+			return nil
+		}
+	}
+	// write to the hidden temporary file and rename it over the remote file when complete, so the remote file
+	// is never read half written, the partial file is continued from its size by TransferResume instead
+	dstPath := tempPath(remotePath)
+	var offset int64
+	if TransferResume {
+		dstPath = remotePath + PartFileSuffix
+		offset = t.remotePartOffset(dstPath, localInfo.Size())
+	}
+	var dstFile *sftp.File
+	if offset > 0 {
+		dstFile, err = t.sftpClient.OpenFile(dstPath, os.O_WRONLY)
+		if err == nil {
+			_, err = dstFile.Seek(offset, io.SeekStart)
+		}
+		if err == nil {
+			_, err = srcFile.Seek(offset, io.SeekStart)
+		}
+	} else {
+		dstFile, err = t.sftpClient.Create(dstPath)
+	}
+	if err != nil {
+		return fmt.Errorf("create %s failed, %s", dstPath, err)
+	}
+	// the local stream is hashed while sending
+	var hasher hash.Hash
+	if TransferVerify == "sha256" && !TransferResume {
+		hasher = sha256.New()
+	}
+	n, err := io.Copy(dstFile, t.newUploadReader(srcFile, localInfo.Size()-offset, hasher))
+	if err != nil && TransferResume {
+		// concurrent writes may leave holes after the failed write, keep the partial file continuous
+		dstFile.Truncate(offset + n)
+	}
+	if err == nil {
+		err = syncFile(dstFile)
+	}
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if !TransferResume {
+			t.sftpClient.Remove(dstPath)
+		}
+		return fmt.Errorf("upload %s failed, %s", localPath, err)
+	}
+	if TransferResume {
+		err = t.verifyResumed(localPath, dstPath, false)
+	} else if err = t.verify(localPath, dstPath, hasher); err != nil {
+		t.sftpClient.Remove(dstPath)
+	}
+	if err != nil {
+		return err
+	}
+	if err := t.replace(dstPath, remotePath, localInfo); err != nil {
+		// the complete partial file is kept to be resumed
+		if !TransferResume {
+			t.sftpClient.Remove(dstPath)
+		}
+		return err
+	}
+	t.files++
+	t.bytes += n
+	return nil
+}
+
+// download the remote file or directory into the local directory by DownloadLayout, directories are downloaded
+// recursively, missing local directories are created
+func (t *sftpTransfer) downloadPath(remotePath, localDir string) error {
+	remotePath = path.Clean(remotePath)
+	fileInfo, err := t.sftpClient.Stat(remotePath)
+	if err != nil {
+		return fmt.Errorf("sftp open file failed %s, %s", remotePath, err)
+	}
+	walker := t.sftpClient.Walk(remotePath)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return err
+		}
+		if t.ctx.Err() != nil {
+			return fmt.Errorf("interrupted after %d file(s) downloaded", t.files)
+		}
+		localPath, err := t.layoutPath(localDir, remotePath, fileInfo.Name(), walker.Path())
+		if err != nil {
+			return err
+		}
+		info := walker.Stat()
+		if info.IsDir() {
+			// directories are created with the files by the template layout
+			if DownloadLayout == "template" {
+				continue
+			}
+			if err := os.MkdirAll(localPath, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if other, ok := t.downloaded[localPath]; ok {
+			return fmt.Errorf("%s and %s are downloaded to the same local file %s", other, walker.Path(), localPath)
+		}
+		t.downloaded[localPath] = walker.Path()
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return err
+		}
+		if err := t.downloadFile(walker.Path(), localPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the hidden temporary file of the downloaded local file
+func localTempPath(localPath string) string {
+	return filepath.Join(filepath.Dir(localPath), "."+filepath.Base(localPath)+tempFileSuffix)
+}
+
+func (t *sftpTransfer) downloadFile(remotePath, localPath string) error {
+	srcFile, err := t.sftpClient.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("sftp open file failed %s, %s", remotePath, err)
+	}
+	defer srcFile.Close()
+	remoteInfo, err := srcFile.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %s failed, %s", remotePath, err)
+	}
+	// write to the hidden temporary file and rename it to the local file when verified, so the local file is
+	// never replaced by a corrupt download, the partial file is continued from its size by TransferResume instead.
+	// the file a local symlink points to is replaced, not the symlink
+	if resolved, err := filepath.EvalSymlinks(localPath); err == nil {
+		localPath = resolved
+	}
+	dstPath := localTempPath(localPath)
+	var offset int64
+	if TransferResume {
+		dstPath = localPath + PartFileSuffix
+		offset = localPartOffset(dstPath, remoteInfo.Size())
+	}
+	var dstFile *os.File
+	if offset > 0 {
+		dstFile, err = os.OpenFile(dstPath, os.O_WRONLY, 0644)
+		if err == nil {
+			_, err = dstFile.Seek(offset, io.SeekStart)
+		}
+		if err == nil {
+			_, err = srcFile.Seek(offset, io.SeekStart)
+		}
+	} else {
+		dstFile, err = os.Create(dstPath)
+	}
+	if err != nil {
+		return err
+	}
+	// the received stream is hashed while writing
+	var hasher hash.Hash
+	if TransferVerify == "sha256" && !TransferResume {
+		hasher = sha256.New()
+	}
+	n, err := io.Copy(t.newDownloadWriter(dstFile, hasher), srcFile)
+	if closeErr := dstFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if !TransferResume {
+			os.Remove(dstPath)
+		}
+		return fmt.Errorf("download %s failed, %s", remotePath, err)
+	}
+	if TransferResume {
+		err = t.verifyResumed(dstPath, remotePath, true)
+	} else if err = t.verify(dstPath, remotePath, hasher); err != nil {
+		os.Remove(dstPath)
+	}
+	if err != nil {
+		return err
+	}
+	// the mode of the replaced local file is kept unless it's set by setLocalAttributes
+	if info, err := os.Stat(localPath); err == nil && info.Mode().IsRegular() {
+		os.Chmod(dstPath, info.Mode().Perm())
+	}
+	if err := os.Rename(dstPath, localPath); err != nil {
+		return err
+	}
+	if err := setLocalAttributes(localPath, remoteInfo); err != nil {
+		return err
+	}
+	t.files++
+	t.bytes += n
+	return nil
+}
+
+// run the command on the remote host and return its output
+func (t *sftpTransfer) runOutput(command string) (string, error) {
+	session, err := t.sshClient.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	var outBuffer bytes.Buffer
+	session.Stdout = &outBuffer
+	err = session.Run(command)
+	return outBuffer.String(), err
+}
+
+// e.g. 512B, 1.5KB, 20.0MB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func hexDigest(hasher hash.Hash) string {
+	return hex.EncodeToString(hasher.Sum(nil))
+}
@@ -93,6 +93,24 @@ func ShowRunCommandUsage() {
 	fmt.Println("(1) description:")
 	fmt.Printf("    a) -o, --output, By default if your input is \"log\",the output logs filename will contain the current date numbers like \"ssgo-%s.log\"\n", GetCurrentDateNumbers())
 	fmt.Println("    b) otherwise, the log file'name with be the argument you specified.")
+	ColorPrint("INFO", "", "Example 6", ": rolling execution in batches.\n")
+	fmt.Println("(1) --serial runs hosts in sequential batches, e.g. 5, 10% or 1,5,25%(the last one is repeated until all hosts are done).")
+	fmt.Println("(2) --pause waits between batches, --batch-confirm asks for confirmation before each batch.")
+	fmt.Println("(3) the next batches will not be executed if the failed hosts of a batch exceed --max-fail-percentage.")
+	fmt.Printf("# %s", "ssgo run -i config.ini -g web -c \"systemctl restart nginx\" --serial 1,5,25% --pause 30s --max-fail-percentage 20\n\n")
+	ColorPrint("INFO", "", "Example 7", ": stop starting new hosts once some hosts failed.\n")
+	fmt.Println("(1) --fail-fast stops once any host failed, --max-failures N stops once N hosts failed, the hosts not started are reported as skipped.")
+	fmt.Println("(2) the running hosts will finish by default, --abort-running cancels them too.")
+	fmt.Println("(3) press Ctrl-C to interrupt the running hosts and cancel the hosts not started, press it again to close the connections.")
+	fmt.Printf("# %s", "ssgo run -i config.ini -g all -c \"yum -y update\" --max-failures 3 --abort-running\n\n")
+	ColorPrint("INFO", "", "Example 8", ": retry the failed hosts.\n")
+	fmt.Println("(1) --retries retries connection-level failures with exponential backoff from --retry-delay, --retry-on all retries any failure.")
+	fmt.Println("(2) hosts not finished successfully are written to ssgo-failed-<timestamp>.txt, --rerun-failed runs them again.")
+	fmt.Printf("# %s", "ssgo run -i config.ini -g all -c \"yum -y update\" --retries 3 --retry-delay 5s\n")
+	fmt.Printf("# %s", "ssgo run -i config.ini -g all -c \"yum -y update\" --rerun-failed\n\n")
+	ColorPrint("INFO", "", "Example 9", ": show the progress of large runs.\n")
+	fmt.Println("(1) the progress is shown on stderr, a live progress line in terminal, otherwise a progress line every --progress-interval.")
+	fmt.Printf("# %s", "ssgo run -i config.ini -g all -c \"yum -y update\" -F json --progress plain --progress-interval 30s > result.json\n")
 	return
 }
 
@@ -150,5 +168,129 @@ func ShowFileTransferUsage() {
 	fmt.Println("(1) description:")
 	fmt.Printf("    a) -o, --output, By default if your input is \"log\",the output logs filename will contain the current date numbers like \"ssgo-%s.log\"\n", GetCurrentDateNumbers())
 	fmt.Println("    b) otherwise, the log file'name with be the argument you specified.")
+	ColorPrint("INFO", "", "Example 6", ": transfer directories recursively.\n")
+	fmt.Println("(1) if -s is a directory, it's transferred recursively with the same structure, missing directories are created.")
+	fmt.Println("(2) the downloaded directory is saved as \"<host>_<directory name>\" like the downloaded files.")
+	fmt.Printf("# %s", "ssgo copy -a upload -i config.ini -g web -s ./dist -d /opt/app\n")
+	fmt.Printf("# %s", "ssgo copy -a download -i config.ini -g web -s /etc/nginx -d ./backup\n\n")
+	ColorPrint("INFO", "", "Example 7", ": transfer files matching glob patterns.\n")
+	fmt.Println("(1) -s supports shell-style globs(*, ? and [...]), quote it to keep it from the local shell, every match is transferred.")
+	fmt.Println("(2) a pattern matching nothing is reported as a warning of the host instead of a failure.")
+	fmt.Printf("# %s", "ssgo copy -a download -i config.ini -g web -s \"/var/log/app/*.log\" -d ./logs\n\n")
+	ColorPrint("INFO", "", "Example 8", ": verify the transferred files.\n")
+	fmt.Println("(1) --verify size compares the file sizes, --verify sha256 compares the sha256 of the transferred stream with the remote file.")
+	fmt.Println("(2) hosts with mismatched files are reported as corrupt, which is a failure.")
+	fmt.Printf("# %s", "ssgo copy -a upload -i config.ini -g web -s app.tar.gz -d /opt --verify sha256 -F json\n\n")
+	ColorPrint("INFO", "", "Example 9", ": sync files like rsync.\n")
+	fmt.Println("(1) -a sync skips the files with the same size and modification time on the remote host, --checksum compares sha256 instead.")
+	fmt.Println("(2) --delete deletes the remote files absent locally.")
+	fmt.Printf("# %s", "ssgo copy -a sync -i config.ini -g web -s ./dist -d /opt/app --delete\n\n")
+	ColorPrint("INFO", "", "Example 10", ": resume the interrupted transfers of large files.\n")
+	fmt.Println("(1) with --resume files are written with the \".ssgo-part\" suffix, renamed after verified by sha256 when complete.")
+	fmt.Println("(2) run the same command with --resume again to continue from the partial files, e.g. with --rerun-failed.")
+	fmt.Printf("# %s", "ssgo copy -a upload -i config.ini -g web -s image.iso -d /data --resume --retries 3\n\n")
+	ColorPrint("INFO", "", "Example 11", ": backup the replaced files and rollback.\n")
+	fmt.Println("(1) uploaded files are written to a hidden temporary file and renamed over the remote file when complete.")
+	fmt.Println("(2) --backup keeps the replaced files as \"<name>.<timestamp>.bak\", --rollback restores the latest backups with the same -s and -d.")
+	fmt.Printf("# %s", "ssgo copy -a upload -i config.ini -g web -s nginx.conf -d /etc/nginx --backup\n")
+	fmt.Printf("# %s", "ssgo copy -a upload -i config.ini -g web -s nginx.conf -d /etc/nginx --rollback\n\n")
+	ColorPrint("INFO", "", "Example 12", ": set the mode, ownership and modification time of the transferred files.\n")
+	fmt.Println("(1) --preserve keeps the mode and modification time of the source files, e.g. the executable bit of scripts.")
+	fmt.Println("(2) --mode sets the mode, --owner sets the owner and group like chown, changing the ownership usually needs root.")
+	fmt.Printf("# %s", "ssgo copy -a upload -i config.ini -g web -s app.conf -d /etc/app --mode 0640 --owner app:app\n\n")
+	ColorPrint("INFO", "", "Example 13", ": limit the bandwidth of the transfers.\n")
+	fmt.Println("(1) --bandwidth-limit limits each host, --total-bandwidth-limit limits all hosts together, e.g. 512K, 10M or 1G bytes per second.")
+	fmt.Println("(2) the average throughput of each host is shown in the results.")
+	fmt.Printf("# %s", "ssgo copy -a upload -i config.ini -g web -s app.tar.gz -d /opt --bandwidth-limit 10M --total-bandwidth-limit 100M\n\n")
+	ColorPrint("INFO", "", "Example 14", ": lay out the downloaded files by hosts.\n")
+	fmt.Println("(1) --layout dir saves the files as \"<host>/<remote path>\", instead of \"<host>_<name>\" by default.")
+	fmt.Println("(2) --layout also accepts a template with the fields Host, Group, Path, Dir and Base.")
+	fmt.Printf("# %s", "ssgo copy -a download -i config.ini -g web -s \"/var/log/app/*.log\" -d ./logs --layout dir\n")
+	fmt.Printf("# %s", "ssgo copy -a download -i config.ini -g web -s /etc/app.conf -d ./conf --layout \"{{.Group}}/{{.Host}}/{{.Base}}\"\n\n")
+	ColorPrint("INFO", "", "Example 15", ": distribute a large file to many hosts by relaying.\n")
+	fmt.Println("(1) -a relay uploads the file to the first --seeds hosts only, the hosts having the file send it to the other hosts by sftp.")
+	fmt.Println("(2) every host is verified by the sha256 of the local file, the hosts behind a jump host are uploaded from the local machine.")
+	fmt.Println("(3) --retry-on connect retries the connections of every host, --retry-on all reruns the failed hosts, the hosts waiting for a failed seed host keep waiting while it's rerun.")
+	fmt.Println("(4) the hosts send files by a temporary key of the run, which is added to authorized_keys of the receiving host with restrict,from=\"<sending host>\" and removed after sending.")
+	fmt.Println("(5) the keys left by an interrupted run end with the comment ssgo-relay-<time>-<random>-<host>, remove them by the run command.")
+	fmt.Printf("# %s", "ssgo copy -a relay -i config.ini -g web -s app.tar.gz -d /opt --seeds 3\n")
+	fmt.Printf("# %s", "ssgo run -i config.ini -g web -c \"sed -i '/ ssgo-relay-[0-9-]*-[0-9a-f]*-[^ ]*$/d' ~/.ssh/authorized_keys\"\n")
+	return
+}
+
+func ShowInventoryCommandUsage() {
+	ColorPrint("INFO", "", "Tips: ", "ssgo's inventory command is used for manage inventory files,for more help information,input this:\n")
+	fmt.Printf("# %s", "ssgo inventory -h\n")
+	fmt.Printf("# %s", "ssgo inventory --help\n\n")
+	ColorPrint("INFO", "", "Example 1", ": Convert inventory file between ini, yaml and json formats.\n")
+	fmt.Println("(1) -i flag's argument is the inventory file to convert, the format is detected by file extension(.ini, .yml, .yaml, .json).")
+	fmt.Println("(2) --inventory-format flag can be used if the file extension is not one of them.")
+	fmt.Println("(3) --to flag is the target format, if not specified, it will be detected by --dst file extension.")
+	fmt.Println("(4) if --dst is not specified, the converted inventory will be printed in terminal.")
+	fmt.Printf("# %s", "ssgo inventory convert -i config.ini --to yaml\n")
+	fmt.Printf("# %s", "ssgo inventory convert -i config.ini --dst inventory.json\n")
+	fmt.Printf("# %s", "ssgo inventory convert -i hosts --inventory-format yaml --to ini --dst config.ini\n\n")
+	ColorPrint("INFO", "", "Example 2", ": Use a yaml or json inventory file with list, run and copy commands.\n")
+	fmt.Println("-----------inventory.yml-----------")
+	fmt.Println("vars:")
+	fmt.Println("  user: root")
+	fmt.Println("  pass: root")
+	fmt.Println("groups:")
+	fmt.Println("  - name: web")
+	fmt.Println("    vars:")
+	fmt.Println("      port: 2222")
+	fmt.Println("    hosts:")
+	fmt.Println("      - 192.168.100.2,192.168.100.3-192.168.100.4")
+	fmt.Println("      - host: 192.168.100.5")
+	fmt.Println("        user: admin")
+	fmt.Println("  - name: app")
+	fmt.Println("    children: [web]")
+	fmt.Println("-----------inventory.yml-----------")
+	fmt.Printf("# %s", "ssgo list -i inventory.yml -g app\n")
+	fmt.Printf("# %s", "ssgo run -i inventory.yml -g web -c \"hostname\"\n\n")
+	ColorPrint("INFO", "", "Example 3", ": Use a dynamic inventory from an executable script or a http(s) url.\n")
+	fmt.Println("(1) the script will be run with '--list' argument, both the script and the url should return ansible compatible json.")
+	fmt.Println("(2) ansible_host, ansible_user, ansible_port, ansible_ssh_pass and ansible_ssh_private_key_file variables are supported.")
+	fmt.Println("(3) the result is cached in ~/.cache/ssgo for 5 minutes by default, --inventory-cache-ttl 0 will disable the cache.")
+	fmt.Printf("# %s", "ssgo list -i ./cmdb.py -g all\n")
+	fmt.Printf("# %s", "ssgo run -i https://cmdb.example.com/inventory -g web -c \"hostname\" --inventory-cache-ttl 10m\n")
+	fmt.Printf("# %s", "ssgo inventory convert -i ./cmdb.py --to ini --dst config.ini\n\n")
+	ColorPrint("INFO", "", "Example 4", ": Use an existing ansible ini or yaml inventory file directly.\n")
+	fmt.Println("(1) [group], [group:vars], [group:children] sections and host ranges like www[01:50].example.com are supported.")
+	fmt.Println("(2) the format is detected by the file content, --inventory-format ansible-ini or ansible-yaml can be used to specify it.")
+	fmt.Printf("# %s", "ssgo run -i hosts -g webservers -c \"hostname\"\n")
+	fmt.Printf("# %s", "ssgo inventory convert -i hosts --to yaml --dst inventory.yml\n\n")
+	ColorPrint("INFO", "", "Example 5", ": Validate the inventory file.\n")
+	fmt.Println("(1) unparseable host tokens, invalid login user or port, unknown host groups and circular children are reported as errors.")
+	fmt.Println("(2) hosts overlapping across host groups and host ranges larger than --max-range-size are reported as warnings.")
+	fmt.Println("(3) ssgo exits with non-zero code if any error found, or any warning found with --strict flag.")
+	fmt.Printf("# %s", "ssgo inventory validate -i config.ini\n")
+	fmt.Printf("# %s", "ssgo inventory validate -i config.ini -g web --strict\n")
+	return
+}
+
+func ShowVaultCommandUsage() {
+	ColorPrint("INFO", "", "Tips: ", "ssgo's vault command is used for encrypt passwords in inventory files,for more help information,input this:\n")
+	fmt.Printf("# %s", "ssgo vault -h\n")
+	fmt.Printf("# %s", "ssgo vault --help\n\n")
+	ColorPrint("INFO", "", "Example 1", ": Encrypt the whole inventory file, it will be decrypted when loading by -i flag.\n")
+	fmt.Println("(1) the key is derived from the vault password by scrypt, the file is encrypted by AES-256-GCM.")
+	fmt.Println("(2) the vault password is prompted in terminal, or read from the file specified by --vault-password-file flag.")
+	fmt.Printf("# %s", "ssgo vault encrypt config.ini\n")
+	fmt.Printf("# %s", "ssgo run -i config.ini -g web -c \"hostname\" --vault-password-file ~/.ssgo_vault_pass\n")
+	fmt.Printf("# %s", "ssgo vault edit config.ini\n")
+	fmt.Printf("# %s", "ssgo vault decrypt config.ini --stdout\n\n")
+	ColorPrint("INFO", "", "Example 2", ": Encrypt a single password, and paste it into the inventory file.\n")
+	fmt.Printf("# %s", "ssgo vault encrypt --vault-password-file ~/.ssgo_vault_pass\n")
+	fmt.Println("Value to encrypt:")
+	fmt.Println("$SSGO_VAULT;1.0;AES256-GCM;3q2+7wAAAA...")
+	fmt.Println("-----------config.ini-----------")
+	fmt.Println("[web]")
+	fmt.Println("user = root")
+	fmt.Println("pass = $SSGO_VAULT;1.0;AES256-GCM;3q2+7wAAAA...")
+	fmt.Println("hosts = 192.168.100.2-192.168.100.4")
+	fmt.Print("-----------config.ini-----------\n\n")
+	ColorPrint("INFO", "", "Example 3", ": Change the vault password of the encrypted inventory files and passwords.\n")
+	fmt.Printf("# %s", "ssgo vault rekey config.ini inventory.yml --new-vault-password-file ~/.ssgo_vault_pass_new\n")
 	return
 }
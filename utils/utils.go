@@ -9,7 +9,9 @@ import (
 	"github.com/bndr/gotabulate"
 	"github.com/daviddengcn/go-colortext"
 	"github.com/go-ini/ini"
+	"golang.org/x/term"
 	"io/ioutil"
+	"math/rand"
 	"net"
 	"os"
 	"path/filepath" // cross platform for windows & linux
@@ -25,6 +27,8 @@ type ResultLogs struct {
 	HostGroup      string
 	SuccessHosts   []interface{}
 	ErrorHosts     []interface{}
+	SkippedHosts   []interface{}
+	CancelledHosts []interface{}
 	EndTime        string
 	CostTime       string
 	TotalHostsInfo string
@@ -94,6 +98,28 @@ func CheckIp(strIPAddress string) bool {
 	return true
 }
 
+// check host name like web01 or web01.example.com, the last label can't be all numbers(looks like a wrong IP Address)
+func CheckHostName(hostName string) bool {
+	if len(hostName) == 0 || len(hostName) > 253 {
+		return false
+	}
+	labels := strings.Split(hostName, ".")
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	if _, err := strconv.Atoi(labels[len(labels)-1]); err == nil {
+		return false
+	}
+	return true
+}
+
 // 将IP地址的掩码转换为CIDR格式的掩码，比如，255.255.255.0 转换为 24
 func IPMaskToCIDRMask(netmask string) (bool, string) {
 	netMasks := strings.Split(netmask, ".")
@@ -172,53 +198,173 @@ func GetFileContent(strFilePath string) ([]string, error) {
 //192.168.100.208"
 //
 func GetAvailableIPFromFile(strFilePath string) ([]string, error) {
-	var availableIPs []string
-	strContent, err := GetFileContent(strFilePath)
+	ips, _, err := GetAvailableIPSourcesFromFile(strFilePath)
+	return ips, err
+}
+
+// 从文件中获取可用IP地址清单，以及每个IP地址所在的文件行，比如：hosts.example.txt:2
+func GetAvailableIPSourcesFromFile(strFilePath string) ([]string, []string, error) {
+	var availableIPs, sources []string
+	path, err := GetRealPath(strings.TrimSpace(strFilePath))
 	if err != nil {
-		return availableIPs, err
+		return availableIPs, sources, err
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return availableIPs, sources, err
 	}
-	if len(strContent) == 0 {
-		return availableIPs, errors.New("ERROR: Nothing found in '" + strFilePath + "' please check your input file content!")
+	if len(strings.TrimSpace(string(buf))) == 0 {
+		return availableIPs, sources, errors.New("ERROR: Nothing found in '" + strFilePath + "' please check your input file content!")
 	}
-	for _, strIps := range strContent {
+	for i, strIps := range strings.Split(string(buf), "\n") {
 		ips, err := GetAvailableIP(strIps)
 		if err != nil {
 			continue
 		}
 		availableIPs = append(availableIPs, ips...)
+		for range ips {
+			sources = append(sources, fmt.Sprintf("%s:%d", strFilePath, i+1))
+		}
 	}
 	if len(availableIPs) == 0 {
-		return availableIPs, errors.New("ERROR: no valid IP Address found, please check your input file, '" + strFilePath + "'")
+		return availableIPs, sources, errors.New("ERROR: no valid IP Address found, please check your input file, '" + strFilePath + "'")
 	}
-	retIPs, err := DuplicateIPAddressCheck(availableIPs)
+	return availableIPs, sources, nil
+}
+
+// how to handle duplicate hosts, one of DuplicateModes, by default "prompt" if stdin is a terminal, otherwise "dedupe"
+var OnDuplicate string
+
+var DuplicateModes = []string{"dedupe", "keep", "fail", "prompt"}
+
+// how to sort hosts, one of HostSortModes, keep the input order by default
+var HostSort string
+
+var HostSortModes = []string{"none", "ip", "name", "random"}
+
+// 检测解析后的IP地址清单中是否包含重复IP地址，并根据OnDuplicate确定是否移除这些重复IP地址，然后根据HostSort排序
+func DuplicateIPAddressCheck(ips []string) ([]string, error) {
+	var retIPs []string
+	indexes, err := DuplicateCheck(ips, nil)
 	if err != nil {
-		return availableIPs, err
+		return retIPs, err
+	}
+	for _, i := range indexes {
+		retIPs = append(retIPs, ips[i])
 	}
-	return retIPs, nil
+	return SortIPAddresses(retIPs, HostSort), nil
 }
 
-// 检测解析后的IP地址清单中是否包含重复IP地址，并接收用户输入以确定是否移除这些重复IP地址
-func DuplicateIPAddressCheck(ips []string) ([]string, error) {
-	sort.Strings(ips)
-	if len(ips) > len(Duplicate(ips)) {
-	LabelConfirm:
-		ok, err := Confirm("Duplicate IP Address Found, input 'yes or y' to remove duplicate IP Address, Input 'no or n' will keep duplicate IP Address,\nnothing will do by default! (y/n) ")
-		if err != nil {
-			goto LabelConfirm
+// sort IP Addresses or host names, "ip" sorts IP Addresses numerically(host names after them),
+// "name" sorts them as strings, "random" shuffles them, and the input order is kept for "none"
+func SortIPAddresses(ips []string, mode string) []string {
+	var retIPs []string
+	for _, i := range SortIndexes(ips, mode) {
+		retIPs = append(retIPs, ips[i])
+	}
+	return retIPs
+}
+
+// get the sorted indexes of the IP Addresses, the original slice is not changed
+func SortIndexes(ips []string, mode string) []int {
+	indexes := make([]int, len(ips))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	switch mode {
+	case "ip":
+		sort.SliceStable(indexes, func(i, j int) bool {
+			a, b := net.ParseIP(ips[indexes[i]]), net.ParseIP(ips[indexes[j]])
+			switch {
+			case a != nil && b != nil:
+				return bytes.Compare(a.To16(), b.To16()) < 0
+			case a != nil || b != nil:
+				return a != nil
+			}
+			return ips[indexes[i]] < ips[indexes[j]]
+		})
+	case "name":
+		sort.SliceStable(indexes, func(i, j int) bool {
+			return ips[indexes[i]] < ips[indexes[j]]
+		})
+	case "random":
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		r.Shuffle(len(indexes), func(i, j int) {
+			indexes[i], indexes[j] = indexes[j], indexes[i]
+		})
+	}
+	return indexes
+}
+
+// check duplicate items, report where they come from(sources[i] is the source of items[i], can be nil),
+// and returns the indexes of items to keep according to OnDuplicate, only the first one is kept if dedupe
+func DuplicateCheck(items []string, sources []string) ([]int, error) {
+	var indexes, duplicates []int
+	var duplicateItems []string
+	found := map[string][]int{}
+	for i, item := range items {
+		if len(found[item]) == 1 {
+			duplicateItems = append(duplicateItems, item)
 		}
-		if ok {
-			// remove duplicate IP Address
-			sort.Strings(ips)
-			retAvailableIPs, err := DuplicateToStringSlice(ips)
-			if err != nil {
-				return retAvailableIPs, err
+		found[item] = append(found[item], i)
+		if len(found[item]) == 1 {
+			indexes = append(indexes, i)
+		} else {
+			duplicates = append(duplicates, i)
+		}
+	}
+	if len(duplicates) == 0 {
+		return indexes, nil
+	}
+
+	mode := OnDuplicate
+	if mode == "" {
+		mode = "dedupe"
+		if IsStdinTerminal() {
+			mode = "prompt"
+		}
+	}
+	for _, item := range duplicateItems {
+		var where []string
+		for _, i := range found[item] {
+			if i < len(sources) && sources[i] != "" {
+				where = append(where, sources[i])
 			}
-			return retAvailableIPs, nil
 		}
-		// else did nothing keep duplicate IP Address, and return
-		return ips, nil
+		if len(where) == 0 {
+			ColorPrint("WARNING", "", "WARNING: ", fmt.Sprintf("duplicate host %s found %d times\n", item, len(found[item])))
+		} else {
+			ColorPrint("WARNING", "", "WARNING: ", fmt.Sprintf("duplicate host %s found %d times: %s\n", item, len(found[item]), strings.Join(where, ", ")))
+		}
 	}
-	return ips, nil
+
+	if mode == "prompt" {
+		for retry := 0; ; retry++ {
+			ok, err := Confirm("Duplicate IP Address Found, input 'yes or y' to remove duplicate IP Address, Input 'no or n' will keep duplicate IP Address! (y/n) ")
+			if err == nil {
+				mode = "keep"
+				if ok {
+					mode = "dedupe"
+				}
+				break
+			}
+			if retry == 2 {
+				return indexes, fmt.Errorf("ERROR: no valid answer for duplicate hosts, please specify --on-duplicate")
+			}
+		}
+	}
+	switch mode {
+	case "keep":
+		indexes = make([]int, len(items))
+		for i := range items {
+			indexes[i] = i
+		}
+		return indexes, nil
+	case "fail":
+		return indexes, fmt.Errorf("ERROR: %d duplicate host(s) found: %s", len(duplicateItems), strings.Join(duplicateItems, ","))
+	}
+	ColorPrint("INFO", "", "Tips: ", fmt.Sprintf("%d duplicate host(s) removed\n", len(duplicates)))
+	return indexes, nil
 }
 
 //从多行文本获取可用IP地址
@@ -499,7 +645,7 @@ func DiffStringSlices(slice1 []string, slice2 []string) []string {
 // 接受用户输入，确认是否继续下一步操作
 func Confirm(str string) (bool, error) {
 	var isTrue string
-	fmt.Printf(str)
+	fmt.Print(str)
 	fmt.Scanln(&isTrue)
 	trueOrFalse, err := ParseBool(isTrue)
 	if err != nil {
@@ -508,6 +654,27 @@ func Confirm(str string) (bool, error) {
 	return trueOrFalse, nil
 }
 
+// 标准输入是否为终端，在cron或CI中运行时不是
+func IsStdinTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// 标准输出和标准错误是否都为终端，重定向到文件或管道时不是
+func IsStdoutTerminal() bool {
+	return term.IsTerminal(int(os.Stdout.Fd())) && term.IsTerminal(int(os.Stderr.Fd()))
+}
+
+// 从终端读取密码，不回显
+func ReadPassword(prompt string) ([]byte, error) {
+	if !IsStdinTerminal() {
+		return nil, fmt.Errorf("stdin is not a terminal")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	return password, err
+}
+
 //从用户输入内容中解析 布尔值 true or false
 func ParseBool(str string) (value bool, err error) {
 	switch str {
@@ -550,13 +717,16 @@ func Cfg(iniFilePath string) (*ini.File, error) {
 		fmt.Println("Default config.ini not exist")
 		return cf, fmt.Errorf("default config.ini not exist, please confirm")
 	}
-	iniFile, _ := filepath.Abs(iniFilePath)
-	cfg, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, iniFile)
-	cfg.BlockMode = false
-
+	// vault encrypted config file is decrypted transparently
+	buf, err := ReadInventoryFile(iniFilePath)
+	if err != nil {
+		return cf, err
+	}
+	cfg, err := ini.LoadSources(ini.LoadOptions{IgnoreInlineComment: true}, buf)
 	if err != nil {
 		return cf, fmt.Errorf("failed to read config file,please check:%v", err)
 	}
+	cfg.BlockMode = false
 	return cfg, nil
 }
 
@@ -593,6 +763,22 @@ func PrintListHosts(hosts []string, maxTableCellWidth int, groupName ...string)
 	PrintResultInTable(headers, data, maxTableCellWidth)
 }
 
+// print hosts of inventory host groups, with the login user, port and jump host of each host
+func PrintListInventoryHosts(hosts []Host, maxTableCellWidth int) {
+	var data [][]string
+	headers := []string{"#", "Host", "User", "Port", "Jump", "Group Name"}
+	todoHosts, err := DuplicateHostCheck(hosts)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for i, h := range todoHosts {
+		data = append(data, []string{strconv.Itoa(i + 1), h.Address, h.User, strconv.Itoa(h.Port), h.Jump, h.Group})
+	}
+	ColorPrint("INFO", "", ">>> Available Hosts", ":\n")
+	PrintResultInTable(headers, data, maxTableCellWidth)
+}
+
 // format result with table style, supports output of the contents of the specified column
 func FormatResultWithTableStyle(res []interface{}, maxTableCellWidth int, notIncludedFields []string) {
 	var header = []string{"#"}
@@ -633,14 +819,14 @@ func FormatResultWithTableStyle(res []interface{}, maxTableCellWidth int, notInc
 				var r []string
 				for _, f := range headerInclude {
 					if f == typeName.Field(i).Name {
-						r = append(r, value.Field(i).String())
+						r = append(r, fmt.Sprint(value.Field(i).Interface()))
 					} else {
 						continue
 					}
 				}
 				row = append(row, r...)
 			} else {
-				row = append(row, value.Field(i).String())
+				row = append(row, fmt.Sprint(value.Field(i).Interface()))
 			}
 		}
 		data = append(data, row)
@@ -651,9 +837,12 @@ func FormatResultWithTableStyle(res []interface{}, maxTableCellWidth int, notInc
 func FormatResultWithBasicStyle(i int, res SSHResult) {
 	ColorPrint("INFO", "", ">>> ", fmt.Sprintf("No.%d, ", i+1))
 	ColorPrint("INFO", "", "Host:", fmt.Sprintf("%s,", res.Host))
-	if res.Status == "success" {
+	switch res.Status {
+	case "success":
 		ColorPrint("INFO", " Status:", fmt.Sprintf("%s", res.Status))
-	} else {
+	case "skipped", "cancelled":
+		ColorPrint("WARNING", " Status:", fmt.Sprintf("%s", res.Status))
+	default:
 		ColorPrint("ERROR", " Status:", fmt.Sprintf("%s", res.Status))
 	}
 	ColorPrint("INFO", ", Results:\n", "", fmt.Sprintf("%s\n\n", res.Result))
@@ -667,9 +856,12 @@ func LogSSHResultToFile(i int, res SSHResult, filePath string) {
 func SFTPFormatResultWithBasicStyle(i int, res SFTPResult) {
 	ColorPrint("INFO", "", ">>> ", fmt.Sprintf("No.%d, ", i+1))
 	ColorPrint("INFO", "", "Host:", fmt.Sprintf("%s,", res.Host))
-	if res.Status == "success" {
+	switch res.Status {
+	case "success":
 		ColorPrint("INFO", " Status:", fmt.Sprintf("%s", res.Status))
-	} else {
+	case "skipped", "cancelled":
+		ColorPrint("WARNING", " Status:", fmt.Sprintf("%s", res.Status))
+	default:
 		ColorPrint("ERROR", " Status:", fmt.Sprintf("%s", res.Status))
 	}
 	ColorPrint("INFO", "", ", Source Path:", fmt.Sprintf("%s,", res.SourcePath))
@@ -684,12 +876,54 @@ func LogSFTPResultToFile(i int, res SFTPResult, filePath string) {
 
 // =============================================================
 // ResultLog format functions
+
+// get the status of SSHResult or SFTPResult, "success", "failed", "corrupt", "skipped" or "cancelled"
+func GetResultStatus(result interface{}) string {
+	switch r := result.(type) {
+	case SSHResult:
+		return r.Status
+	case SFTPResult:
+		return r.Status
+	}
+	return ""
+}
+
+// "corrupt" is a failure too, the transferred files don't match the source
+func IsFailedStatus(status string) bool {
+	return status == "failed" || status == "corrupt"
+}
+
+// add the result to success, failed, skipped or cancelled hosts of the result log by its status
+func AddResultToLog(resultLog *ResultLogs, result interface{}) {
+	switch GetResultStatus(result) {
+	case "failed", "corrupt":
+		resultLog.ErrorHosts = append(resultLog.ErrorHosts, result)
+	case "skipped":
+		resultLog.SkippedHosts = append(resultLog.SkippedHosts, result)
+	case "cancelled":
+		resultLog.CancelledHosts = append(resultLog.CancelledHosts, result)
+	default:
+		resultLog.SuccessHosts = append(resultLog.SuccessHosts, result)
+	}
+}
+
+func GetTotalHostsInfo(resultLog ResultLogs) string {
+	info := fmt.Sprintf("%d(Success) + %d(Failed)", len(resultLog.SuccessHosts), len(resultLog.ErrorHosts))
+	if len(resultLog.SkippedHosts) > 0 {
+		info += fmt.Sprintf(" + %d(Skipped)", len(resultLog.SkippedHosts))
+	}
+	if len(resultLog.CancelledHosts) > 0 {
+		info += fmt.Sprintf(" + %d(Cancelled)", len(resultLog.CancelledHosts))
+	}
+	total := len(resultLog.SuccessHosts) + len(resultLog.ErrorHosts) + len(resultLog.SkippedHosts) + len(resultLog.CancelledHosts)
+	return fmt.Sprintf("%s = %d(Total)", info, total)
+}
 func ResultLogInfo(resultLog ResultLogs, startTime time.Time, logToFile bool, logFilePath string) {
 	endTime := time.Now()
 	resultLog.StartTime = startTime.Format("2006-01-02 15:04:05")
 	resultLog.EndTime = endTime.Format("2006-01-02 15:04:05")
 	resultLog.CostTime = endTime.Sub(startTime).String()
-	resultLog.TotalHostsInfo = fmt.Sprintf("%d(Success) + %d(Failed) = %d(Total)", len(resultLog.SuccessHosts), len(resultLog.ErrorHosts), len(resultLog.SuccessHosts)+len(resultLog.ErrorHosts))
+	resultLog.TotalHostsInfo = GetTotalHostsInfo(resultLog)
 	if logToFile {
 		WriteAndAppendFile(logFilePath, fmt.Sprintf("Tips: process running done."))
 		WriteAndAppendFile(logFilePath, fmt.Sprintf("\nStart Time: %s\nEnd Time: %s\nCost Time: %s\nTotal Hosts Running: %s\n", resultLog.StartTime, resultLog.EndTime, resultLog.CostTime, resultLog.TotalHostsInfo))
@@ -708,21 +942,8 @@ func FormatResultLogWithSimpleStyle(resultLog ResultLogs, startTime time.Time, m
 
 // format result log with table style
 func FormatResultLogWithTableStyle(chs []chan interface{}, resultLog ResultLogs, startTime time.Time, maxTableCellWidth int) {
-	resultStatus := ""
 	for _, resCh := range chs {
-		result := <-resCh
-		switch reflect.TypeOf(result).String() {
-		case "utils.SSHResult":
-			resultStatus = result.(SSHResult).Status
-		case "utils.SFTPResult":
-			resultStatus = result.(SFTPResult).Status
-		}
-		if resultStatus == "failed" {
-			resultLog.ErrorHosts = append(resultLog.ErrorHosts, result)
-
-		} else {
-			resultLog.SuccessHosts = append(resultLog.SuccessHosts, result)
-		}
+		AddResultToLog(&resultLog, <-resCh)
 	}
 
 	if len(resultLog.SuccessHosts) > 0 {
@@ -733,31 +954,26 @@ func FormatResultLogWithTableStyle(chs []chan interface{}, resultLog ResultLogs,
 		ColorPrint("ERROR", "", "WARNING: ", "Failed hosts, please confirm!\n")
 		FormatResultWithTableStyle(resultLog.ErrorHosts, maxTableCellWidth, []string{})
 	}
+	if len(resultLog.SkippedHosts) > 0 {
+		ColorPrint("WARNING", "", "WARNING: ", "Skipped hosts\n")
+		FormatResultWithTableStyle(resultLog.SkippedHosts, maxTableCellWidth, []string{})
+	}
+	if len(resultLog.CancelledHosts) > 0 {
+		ColorPrint("WARNING", "", "WARNING: ", "Cancelled hosts\n")
+		FormatResultWithTableStyle(resultLog.CancelledHosts, maxTableCellWidth, []string{})
+	}
 	ResultLogInfo(resultLog, startTime, false, "")
 }
 
 func GetAllResultLog(chs []chan interface{}, resultLog ResultLogs, startTime time.Time) ResultLogs {
-	resultStatus := ""
 	for _, resCh := range chs {
-		result := <-resCh
-		switch reflect.TypeOf(result).String() {
-		case "utils.SSHResult":
-			resultStatus = result.(SSHResult).Status
-		case "utils.SFTPResult":
-			resultStatus = result.(SFTPResult).Status
-		}
-		if resultStatus == "failed" {
-			resultLog.ErrorHosts = append(resultLog.ErrorHosts, result)
-
-		} else {
-			resultLog.SuccessHosts = append(resultLog.SuccessHosts, result)
-		}
+		AddResultToLog(&resultLog, <-resCh)
 	}
 	endTime := time.Now()
 	resultLog.StartTime = startTime.Format("2006-01-02 15:04:05")
 	resultLog.EndTime = endTime.Format("2006-01-02 15:04:05")
 	resultLog.CostTime = endTime.Sub(startTime).String()
-	resultLog.TotalHostsInfo = fmt.Sprintf("%d(Success) + %d(Failed) = %d(Total)", len(resultLog.SuccessHosts), len(resultLog.ErrorHosts), len(resultLog.SuccessHosts)+len(resultLog.ErrorHosts))
+	resultLog.TotalHostsInfo = GetTotalHostsInfo(resultLog)
 
 	return resultLog
 }
@@ -790,7 +1006,7 @@ func FormatResultLogWithJsonStyle(chs []chan interface{}, resultLog ResultLogs,
 }
 
 func WriteAndAppendFile(filePath, strContent string) {
-	strTime := GetCurrentDateNumbers
+	strTime := GetCurrentDateNumbers()
 	if filePath == "log" {
 		filePath = fmt.Sprintf("ssgo-%s.log", strTime)
 	}
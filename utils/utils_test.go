@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// use the file with the input as stdin, which is not a terminal, restored by the returned func
+func withStdin(t *testing.T, input string) func() {
+	f, err := ioutil.TempFile("", "ssgo-stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(input)
+	f.Seek(0, 0)
+	oldStdin := os.Stdin
+	os.Stdin = f
+	return func() {
+		os.Stdin = oldStdin
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+func TestDuplicateCheck(t *testing.T) {
+	items := []string{"192.168.100.1", "192.168.100.2", "192.168.100.1", "web01", "192.168.100.1"}
+	sources := []string{"config.ini:3 [web]", "config.ini:4 [web]", "config.ini:8 [db]", "", "hosts.txt:1"}
+	tests := []struct {
+		mode    string
+		input   string
+		want    []int
+		wantErr bool
+	}{
+		{"dedupe", "", []int{0, 1, 3}, false},
+		{"keep", "", []int{0, 1, 2, 3, 4}, false},
+		{"fail", "", nil, true},
+		// stdin is not a terminal, duplicates are removed without prompting
+		{"", "n\n", []int{0, 1, 3}, false},
+		{"prompt", "y\n", []int{0, 1, 3}, false},
+		{"prompt", "n\n", []int{0, 1, 2, 3, 4}, false},
+		{"prompt", "maybe\nagain\ny\n", []int{0, 1, 3}, false},
+		{"prompt", "", nil, true},
+	}
+	defer func(mode string) { OnDuplicate = mode }(OnDuplicate)
+	for _, tt := range tests {
+		restore := withStdin(t, tt.input)
+		OnDuplicate = tt.mode
+		got, err := DuplicateCheck(items, sources)
+		restore()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("DuplicateCheck(%s, %q) doesn't fail", tt.mode, tt.input)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("DuplicateCheck(%s, %q) = %v, %v, want %v", tt.mode, tt.input, got, err, tt.want)
+		}
+	}
+	// no duplicates, nothing is asked
+	OnDuplicate = "fail"
+	if got, err := DuplicateCheck([]string{"web01", "web02"}, nil); err != nil || !reflect.DeepEqual(got, []int{0, 1}) {
+		t.Errorf("DuplicateCheck without duplicates = %v, %v, want [0 1]", got, err)
+	}
+}
+
+func TestSortIndexes(t *testing.T) {
+	ips := []string{"192.168.100.10", "web01", "192.168.100.9", "fe80::1", "10.0.0.1", "app01", "192.168.100.9"}
+	tests := []struct {
+		mode string
+		want []int
+	}{
+		{"", []int{0, 1, 2, 3, 4, 5, 6}},
+		{"none", []int{0, 1, 2, 3, 4, 5, 6}},
+		// IPv4 before IPv6 by their 16 bytes form, host names last, the equal ones keep their order
+		{"ip", []int{4, 2, 6, 0, 3, 5, 1}},
+		{"name", []int{4, 0, 2, 6, 5, 3, 1}},
+	}
+	for _, tt := range tests {
+		if got := SortIndexes(ips, tt.mode); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SortIndexes(%s) = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+	// random order is a permutation of the indexes
+	got := SortIndexes(ips, "random")
+	sort.Ints(got)
+	if !reflect.DeepEqual(got, []int{0, 1, 2, 3, 4, 5, 6}) {
+		t.Errorf("SortIndexes(random) = %v, not a permutation", got)
+	}
+	if ips[0] != "192.168.100.10" || ips[3] != "fe80::1" {
+		t.Errorf("SortIndexes changes the original slice, %v", ips)
+	}
+}
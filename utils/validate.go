@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// a problem found in the inventory file, Level is "ERROR" or "WARNING"
+type InventoryIssue struct {
+	Level   string
+	Group   string
+	Line    int
+	Message string
+}
+
+// where the issue is, e.g. "config.ini:12 [web]"
+func (issue InventoryIssue) Location(inventoryFilePath string) string {
+	return inventoryLocation(inventoryFilePath, issue.Line, issue.Group)
+}
+
+type inventoryHostRef struct {
+	Group string
+	Line  int
+}
+
+// validate the inventory, reports unparseable host tokens, invalid login variables, unknown groups,
+// circular children, hosts overlapping across groups and ranges expanded to more than maxRangeSize hosts
+func ValidateInventory(inv *Inventory, groupName string, maxRangeSize int) []InventoryIssue {
+	var issues []InventoryIssue
+	addIssue := func(level, group string, line int, format string, a ...interface{}) {
+		issues = append(issues, InventoryIssue{Level: level, Group: group, Line: line, Message: fmt.Sprintf(format, a...)})
+	}
+	if groupName != "" && groupName != inventoryAllGroup {
+		if _, err := inv.GetGroup(groupName); err != nil {
+			addIssue("ERROR", "", 0, "host group '%s' not found, available host groups: %s", groupName, strings.Join(inv.GroupNames(), ","))
+		}
+	}
+
+	var addresses []string
+	hostRefs := map[string][]inventoryHostRef{}
+	for _, g := range inv.Groups {
+		if len(g.Hosts) == 0 && len(g.Children) == 0 {
+			addIssue("WARNING", g.Name, 0, "host group has neither hosts nor children")
+		}
+		for _, c := range g.Children {
+			if _, err := inv.GetGroup(c); err != nil {
+				addIssue("ERROR", g.Name, 0, "unknown children host group '%s'", c)
+			}
+		}
+		if _, err := inv.GetGroupHosts(g.Name, true); err != nil && strings.Contains(err.Error(), "circular") {
+			addIssue("ERROR", g.Name, 0, "host group has a circular children relationship")
+		}
+		groupVars, err := inv.GetGroupVars(g.Name)
+		if err != nil {
+			continue
+		}
+		if len(g.Hosts) > 0 {
+			// warn about the hosts setting neither the login variables nor inheriting them, at group level if it's all of them
+			var noUser, noAuth []InventoryHostEntry
+			parsed := 0
+			for _, e := range g.Hosts {
+				if e.Err != nil {
+					continue
+				}
+				parsed++
+				if groupVars["user"] == "" && e.Vars["user"] == "" {
+					noUser = append(noUser, e)
+				}
+				if groupVars["pass"] == "" && groupVars["key"] == "" && e.Vars["pass"] == "" && e.Vars["key"] == "" {
+					noAuth = append(noAuth, e)
+				}
+			}
+			if len(noUser) == parsed {
+				addIssue("WARNING", g.Name, 0, "login user is not set, 'root' will be used")
+			} else {
+				for _, e := range noUser {
+					addIssue("WARNING", g.Name, e.Line, "login user is not set for '%s', 'root' will be used", e.Expr)
+				}
+			}
+			if len(noAuth) == parsed {
+				addIssue("WARNING", g.Name, 0, "neither pass nor key is set")
+			} else {
+				for _, e := range noAuth {
+					addIssue("WARNING", g.Name, e.Line, "neither pass nor key is set for '%s'", e.Expr)
+				}
+			}
+			if _, err := NewHostFromVars("", groupVars, g.Name); err != nil {
+				addIssue("ERROR", g.Name, 0, "invalid port '%s'", groupVars["port"])
+			}
+		}
+
+		lineHosts := map[int]int{}
+		for i, e := range g.Hosts {
+			line := e.Line
+			if e.Err != nil {
+				addIssue("ERROR", g.Name, line, "%s", strings.TrimPrefix(e.Err.Error(), "ERROR: "))
+				continue
+			}
+			if user, ok := e.Vars["user"]; ok && user == "" {
+				addIssue("ERROR", g.Name, line, "empty login user for '%s'", e.Expr)
+			}
+			if _, ok := e.Vars["port"]; ok {
+				if _, err := NewHostFromVars(e.Expr, e.Vars, g.Name); err != nil {
+					addIssue("ERROR", g.Name, line, "invalid port '%s' for '%s'", e.Vars["port"], e.Expr)
+				}
+			}
+			if strings.TrimSpace(e.Expr) == "" {
+				addIssue("ERROR", g.Name, line, "host item #%d has no host", i+1)
+				continue
+			}
+			for _, token := range strings.Split(e.Expr, ",") {
+				token = strings.TrimSpace(token)
+				if token == "" || strings.HasPrefix(token, "#") {
+					continue
+				}
+				ips, err := GetAvailableIPList(token)
+				if err != nil {
+					if !CheckHostName(token) {
+						if line == 0 {
+							addIssue("ERROR", g.Name, line, "unparseable host token '%s' in host item #%d", token, i+1)
+						} else {
+							addIssue("ERROR", g.Name, line, "unparseable host token '%s'", token)
+						}
+						continue
+					}
+					ips = []string{token}
+				}
+				if line == 0 && len(ips) > maxRangeSize {
+					addIssue("WARNING", g.Name, line, "host range '%s' contains %d hosts, more than %d", token, len(ips), maxRangeSize)
+				}
+				lineHosts[line] += len(ips)
+				for _, ip := range ips {
+					if _, ok := hostRefs[ip]; !ok {
+						addresses = append(addresses, ip)
+					}
+					hostRefs[ip] = append(hostRefs[ip], inventoryHostRef{Group: g.Name, Line: line})
+				}
+			}
+		}
+		for _, e := range g.Hosts {
+			if n := lineHosts[e.Line]; e.Line > 0 && n > maxRangeSize {
+				addIssue("WARNING", g.Name, e.Line, "host range line contains %d hosts, more than %d", n, maxRangeSize)
+				delete(lineHosts, e.Line)
+			}
+		}
+	}
+
+	for _, ip := range addresses {
+		refs := hostRefs[ip]
+		if len(refs) < 2 {
+			continue
+		}
+		var where []string
+		groups := map[string]bool{}
+		for _, ref := range refs {
+			groups[ref.Group] = true
+			if ref.Line > 0 {
+				where = append(where, fmt.Sprintf("%s(line %d)", ref.Group, ref.Line))
+			} else {
+				where = append(where, ref.Group)
+			}
+		}
+		if len(groups) > 1 {
+			addIssue("WARNING", "", 0, "host %s overlaps across host groups: %s", ip, strings.Join(where, ", "))
+		} else {
+			addIssue("WARNING", refs[0].Group, refs[1].Line, "host %s is duplicated in the host group: %s", ip, strings.Join(where, ", "))
+		}
+	}
+	return issues
+}
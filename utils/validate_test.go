@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestValidateInventory(t *testing.T) {
+	inv := &Inventory{
+		Path: "config.ini",
+		Vars: map[string]string{},
+		Groups: []*InventoryGroup{
+			{Name: "web", Vars: map[string]string{"user": "root", "pass": "secret"}, Hosts: []InventoryHostEntry{
+				{Expr: "192.168.100.1", Line: 3},
+				{Expr: "web_01", Line: 4},
+				{Expr: "192.168.100.1", Line: 5},
+				{Expr: "192.168.100.2", Vars: map[string]string{"port": "70000"}, Line: 6},
+				{Expr: "192.168.100.3", Vars: map[string]string{"user": ""}, Line: 7},
+				{Err: errors.New("ERROR: unknown host variable 'password' in '192.168.100.4 password=x'"), Line: 8},
+				{Expr: "10.0.0.10-10.0.0.200", Line: 9},
+			}},
+			{Name: "db", Vars: map[string]string{}, Hosts: []InventoryHostEntry{
+				{Expr: "192.168.100.2", Line: 12},
+				{Expr: "db01.example.com", Vars: map[string]string{"user": "admin", "key": "~/.ssh/db.pem"}, Line: 13},
+			}},
+			{Name: "app", Vars: map[string]string{"port": "ssh"}, Children: []string{"web", "missing"}},
+			{Name: "empty", Vars: map[string]string{}},
+			{Name: "loop1", Vars: map[string]string{}, Children: []string{"loop2"}},
+			{Name: "loop2", Vars: map[string]string{}, Children: []string{"loop1"}},
+		},
+	}
+	tests := []struct {
+		group string
+		want  []string
+	}{
+		{"web", []string{
+			"ERROR config.ini [web] invalid port 'ssh'",
+			"ERROR config.ini:4 [web] unparseable host token 'web_01'",
+			"ERROR config.ini:6 [web] invalid port '70000' for '192.168.100.2'",
+			"ERROR config.ini:7 [web] empty login user for '192.168.100.3'",
+			"ERROR config.ini:8 [web] unknown host variable 'password' in '192.168.100.4 password=x'",
+			"WARNING config.ini:9 [web] host range line contains 191 hosts, more than 100",
+			"WARNING config.ini:12 [db] login user is not set for '192.168.100.2', 'root' will be used",
+			"WARNING config.ini:12 [db] neither pass nor key is set for '192.168.100.2'",
+			"ERROR config.ini [app] unknown children host group 'missing'",
+			"WARNING config.ini [empty] host group has neither hosts nor children",
+			"ERROR config.ini [loop1] host group has a circular children relationship",
+			"ERROR config.ini [loop2] host group has a circular children relationship",
+			"WARNING config.ini:5 [web] host 192.168.100.1 is duplicated in the host group: web(line 3), web(line 5)",
+			"WARNING config.ini host 192.168.100.2 overlaps across host groups: web(line 6), db(line 12)",
+		}},
+		{"unknown", []string{"ERROR config.ini host group 'unknown' not found, available host groups: web,db,app,empty,loop1,loop2"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, issue := range ValidateInventory(inv, tt.group, 100) {
+			got = append(got, fmt.Sprintf("%s %s %s", issue.Level, issue.Location(inv.Path), issue.Message))
+		}
+		// the issues of the other groups are the same as above
+		if tt.group == "unknown" && len(got) > 0 {
+			got = got[:1]
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ValidateInventory(%s) =\n%q\nwant\n%q", tt.group, got, tt.want)
+		}
+	}
+}
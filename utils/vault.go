@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// encrypted inventory file starts with the header line, encrypted value is the header and data joined by ";"
+// e.g. pass = $SSGO_VAULT;1.0;AES256-GCM;c2FsdC4uLm5vbmNlLi4uY2lwaGVydGV4dA==
+const VaultHeader = "$SSGO_VAULT;1.0;AES256-GCM"
+
+const (
+	vaultSaltSize = 16
+	vaultKeySize  = 32
+)
+
+var vaultValueRegexp = regexp.MustCompile(regexp.QuoteMeta(VaultHeader+";") + `[A-Za-z0-9+/=]+`)
+
+// file of vault password, the password will be prompted in terminal if it's empty
+var VaultPasswordFile string
+
+// vault password is kept in memory once read
+var vaultPassword []byte
+
+// derive the AES-256 key from the vault password by scrypt
+func vaultKey(password, salt []byte) ([]byte, error) {
+	return scrypt.Key(password, salt, 32768, 8, 1, vaultKeySize)
+}
+
+// encrypt data with AES-256-GCM, returns salt + nonce + ciphertext
+func VaultEncrypt(plain, password []byte) ([]byte, error) {
+	salt := make([]byte, vaultSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	key, err := vaultKey(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	data := append(salt, nonce...)
+	return gcm.Seal(data, nonce, plain, []byte(VaultHeader)), nil
+}
+
+func VaultDecrypt(data, password []byte) ([]byte, error) {
+	if len(data) < vaultSaltSize {
+		return nil, fmt.Errorf("ERROR: invalid vault data, too short")
+	}
+	key, err := vaultKey(password, data[:vaultSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	data = data[vaultSaltSize:]
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ERROR: invalid vault data, too short")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(VaultHeader))
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: vault decryption failed, please check the vault password")
+	}
+	return plain, nil
+}
+
+func IsVaultFile(buf []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(buf), []byte(VaultHeader+"\n"))
+}
+
+func IsVaultValue(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), VaultHeader+";")
+}
+
+// encrypt the whole file content, the base64 data is wrapped at 80 characters
+func EncryptVaultFile(plain, password []byte) ([]byte, error) {
+	data, err := VaultEncrypt(plain, password)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(VaultHeader + "\n")
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 80 {
+		buf.WriteString(encoded[:80] + "\n")
+		encoded = encoded[80:]
+	}
+	buf.WriteString(encoded + "\n")
+	return buf.Bytes(), nil
+}
+
+func DecryptVaultFile(buf, password []byte) ([]byte, error) {
+	if !IsVaultFile(buf) {
+		return nil, fmt.Errorf("ERROR: not a vault encrypted file")
+	}
+	lines := strings.Split(strings.TrimSpace(string(buf)), "\n")
+	data, err := base64.StdEncoding.DecodeString(strings.Join(lines[1:], ""))
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: invalid vault file, %s", err)
+	}
+	return VaultDecrypt(data, password)
+}
+
+func EncryptVaultValue(plain string, password []byte) (string, error) {
+	data, err := VaultEncrypt([]byte(plain), password)
+	if err != nil {
+		return "", err
+	}
+	return VaultHeader + ";" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func DecryptVaultValue(value string, password []byte) (string, error) {
+	if !IsVaultValue(value) {
+		return "", fmt.Errorf("ERROR: not a vault encrypted value")
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(value), VaultHeader+";"))
+	if err != nil {
+		return "", fmt.Errorf("ERROR: invalid vault value, %s", err)
+	}
+	plain, err := VaultDecrypt(data, password)
+	return string(plain), err
+}
+
+// replace every encrypted value in the text by the result of fn, e.g. decrypt or rekey them
+func ReplaceVaultValues(text string, fn func(value string) (string, error)) (string, int, error) {
+	var replaceErr error
+	count := 0
+	ret := vaultValueRegexp.ReplaceAllStringFunc(text, func(value string) string {
+		if replaceErr != nil {
+			return value
+		}
+		newValue, err := fn(value)
+		if err != nil {
+			replaceErr = err
+			return value
+		}
+		count++
+		return newValue
+	})
+	return ret, count, replaceErr
+}
+
+// get the vault password from --vault-password-file, or prompt in terminal
+func GetVaultPassword() ([]byte, error) {
+	if vaultPassword != nil {
+		return vaultPassword, nil
+	}
+	var password []byte
+	var err error
+	if VaultPasswordFile != "" {
+		password, err = readVaultPasswordFile(VaultPasswordFile)
+	} else if password, err = ReadPassword("Vault password: "); err != nil {
+		err = fmt.Errorf("ERROR: vault password is required, please specify --vault-password-file, %s", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(password) == 0 {
+		return nil, fmt.Errorf("ERROR: vault password can't be empty")
+	}
+	vaultPassword = password
+	return vaultPassword, nil
+}
+
+// the trailing newline of the password file is ignored
+func readVaultPasswordFile(passwordFile string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(passwordFile)
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: read vault password file failed, %s", err)
+	}
+	return bytes.TrimRight(buf, "\r\n"), nil
+}
+
+// read the inventory file, decrypt it if it's a vault encrypted file
+func ReadInventoryFile(inventoryFilePath string) ([]byte, error) {
+	realPath, err := GetRealPath(inventoryFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("%s not exist! please check", inventoryFilePath)
+	}
+	buf, err := ioutil.ReadFile(realPath)
+	if err != nil {
+		return nil, err
+	}
+	if !IsVaultFile(buf) {
+		return buf, nil
+	}
+	password, err := GetVaultPassword()
+	if err != nil {
+		return nil, err
+	}
+	return DecryptVaultFile(buf, password)
+}
+
+// whether the inventory file is a vault encrypted file
+func IsVaultInventoryFile(inventoryFilePath string) bool {
+	realPath, err := GetRealPath(inventoryFilePath)
+	if err != nil {
+		return false
+	}
+	buf, err := ioutil.ReadFile(realPath)
+	return err == nil && IsVaultFile(buf)
+}
+
+// decrypt the encrypted login variables of the inventory
+func decryptInventoryVars(inv *Inventory) error {
+	decrypt := func(vars map[string]string) error {
+		for k, v := range vars {
+			if !IsVaultValue(v) {
+				continue
+			}
+			password, err := GetVaultPassword()
+			if err != nil {
+				return err
+			}
+			if vars[k], err = DecryptVaultValue(v, password); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := decrypt(inv.Vars); err != nil {
+		return err
+	}
+	for _, g := range inv.Groups {
+		if err := decrypt(g.Vars); err != nil {
+			return fmt.Errorf("%s, in host group '%s'", err, g.Name)
+		}
+		for _, e := range g.Hosts {
+			if err := decrypt(e.Vars); err != nil {
+				return fmt.Errorf("%s, in host group '%s'", err, g.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// get the new vault password from the password file, or prompt twice in terminal
+func NewVaultPassword(passwordFile string) ([]byte, error) {
+	if passwordFile != "" {
+		password, err := readVaultPasswordFile(passwordFile)
+		if err == nil && len(password) == 0 {
+			err = fmt.Errorf("ERROR: vault password can't be empty")
+		}
+		return password, err
+	}
+	password, err := ReadPassword("New vault password: ")
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: vault password is required, please specify the vault password file, %s", err)
+	}
+	if len(password) == 0 {
+		return nil, fmt.Errorf("ERROR: vault password can't be empty")
+	}
+	confirm, err := ReadPassword("Confirm new vault password: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(password, confirm) {
+		return nil, fmt.Errorf("ERROR: vault passwords do not match")
+	}
+	return password, nil
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestVaultEncryptDecrypt(t *testing.T) {
+	password := []byte("vault-pass")
+	for _, plain := range []string{"", "secret", "p@ss word;with=special\nchars", strings.Repeat("x", 4096)} {
+		data, err := VaultEncrypt([]byte(plain), password)
+		if err != nil {
+			t.Fatalf("VaultEncrypt(%q) failed, %s", plain, err)
+		}
+		got, err := VaultDecrypt(data, password)
+		if err != nil {
+			t.Errorf("VaultDecrypt of %q failed, %s", plain, err)
+		} else if string(got) != plain {
+			t.Errorf("VaultDecrypt = %q, want %q", got, plain)
+		}
+		if _, err := VaultDecrypt(data, []byte("wrong")); err == nil {
+			t.Errorf("VaultDecrypt of %q by the wrong password doesn't fail", plain)
+		}
+	}
+	a, _ := VaultEncrypt([]byte("secret"), password)
+	b, _ := VaultEncrypt([]byte("secret"), password)
+	if bytes.Equal(a, b) {
+		t.Errorf("VaultEncrypt returns the same data twice, the salt and nonce should be random")
+	}
+	if _, err := VaultDecrypt([]byte("short"), password); err == nil {
+		t.Errorf("VaultDecrypt of short data doesn't fail")
+	}
+}
+
+func TestVaultFile(t *testing.T) {
+	password := []byte("vault-pass")
+	plain := []byte("[web]\nhosts = 192.168.100.1\npass = secret\n")
+	buf, err := EncryptVaultFile(plain, password)
+	if err != nil {
+		t.Fatalf("EncryptVaultFile failed, %s", err)
+	}
+	if !IsVaultFile(buf) || IsVaultFile(plain) {
+		t.Errorf("IsVaultFile doesn't tell the encrypted file from the plain one")
+	}
+	if bytes.Contains(buf, []byte("secret")) {
+		t.Errorf("the encrypted file contains the plain text")
+	}
+	got, err := DecryptVaultFile(buf, password)
+	if err != nil {
+		t.Fatalf("DecryptVaultFile failed, %s", err)
+	}
+	if !bytes.Equal(got, plain) {
+		t.Errorf("DecryptVaultFile = %q, want %q", got, plain)
+	}
+	if _, err := DecryptVaultFile(buf, []byte("wrong")); err == nil {
+		t.Errorf("DecryptVaultFile by the wrong password doesn't fail")
+	}
+	if _, err := DecryptVaultFile(plain, password); err == nil {
+		t.Errorf("DecryptVaultFile of the plain file doesn't fail")
+	}
+}
+
+func TestVaultValue(t *testing.T) {
+	password := []byte("vault-pass")
+	value, err := EncryptVaultValue("secret", password)
+	if err != nil {
+		t.Fatalf("EncryptVaultValue failed, %s", err)
+	}
+	if !IsVaultValue(value) || IsVaultValue("secret") {
+		t.Errorf("IsVaultValue doesn't tell the encrypted value from the plain one")
+	}
+	if got, err := DecryptVaultValue(" "+value+" ", password); err != nil || got != "secret" {
+		t.Errorf("DecryptVaultValue = %q, %v, want \"secret\"", got, err)
+	}
+	if _, err := DecryptVaultValue(value, []byte("wrong")); err == nil {
+		t.Errorf("DecryptVaultValue by the wrong password doesn't fail")
+	}
+	if _, err := DecryptVaultValue(VaultHeader+";!!!", password); err == nil {
+		t.Errorf("DecryptVaultValue of invalid base64 doesn't fail")
+	}
+
+	// the values in the inventory text are found and replaced, the other text is kept
+	other, _ := EncryptVaultValue("other", password)
+	text := "[web]\npass = " + value + "\n[db]\npass = " + other + "\nuser = root\n"
+	plain, count, err := ReplaceVaultValues(text, func(v string) (string, error) {
+		return DecryptVaultValue(v, password)
+	})
+	if err != nil || count != 2 {
+		t.Fatalf("ReplaceVaultValues = %d, %v, want 2 values replaced", count, err)
+	}
+	if want := "[web]\npass = secret\n[db]\npass = other\nuser = root\n"; plain != want {
+		t.Errorf("ReplaceVaultValues = %q, want %q", plain, want)
+	}
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// how to verify the transferred files, "size" compares the file sizes, "sha256" compares the sha256 digests
+var VerifyModes = []string{"none", "size", "sha256"}
+
+var TransferVerify = "none"
+
+// the transferred file doesn't match the source, the host is reported with the status "corrupt"
+type corruptError struct {
+	msg string
+}
+
+func (e *corruptError) Error() string {
+	return e.msg
+}
+
+// the status of the failed transfer, "corrupt" or "failed"
+func transferErrorStatus(err error) string {
+	var corrupt *corruptError
+	if errors.As(err, &corrupt) {
+		return "corrupt"
+	}
+	return "failed"
+}
+
+// verify the transferred file by TransferVerify, hasher is the sha256 of the stream sent or received,
+// it's compared with the sha256 of the remote file
+func (t *sftpTransfer) verify(localPath, remotePath string, hasher hash.Hash) error {
+	switch TransferVerify {
+	case "size":
+		localInfo, err := os.Stat(localPath)
+		if err != nil {
+			return err
+		}
+		remoteInfo, err := t.sftpClient.Stat(remotePath)
+		if err != nil {
+			return fmt.Errorf("stat %s failed, %s", remotePath, err)
+		}
+		if localInfo.Size() != remoteInfo.Size() {
+			return &corruptError{fmt.Sprintf("size mismatch, local %s is %d bytes, remote %s is %d bytes", localPath, localInfo.Size(), remotePath, remoteInfo.Size())}
+		}
+	case "sha256":
+		digest := hexDigest(hasher)
+		remoteDigest, err := t.remoteSHA256(remotePath)
+		if err != nil {
+			return err
+		}
+		if digest != remoteDigest {
+			return &corruptError{fmt.Sprintf("sha256 mismatch, %s is %s, remote %s is %s", localPath, digest, remotePath, remoteDigest)}
+		}
+		t.digests = append(t.digests, fmt.Sprintf("%s  %s", digest, targetPath(remotePath)))
+	}
+	return nil
+}
+
+// get the sha256 of the remote file by sha256sum, or read it back by sftp if sha256sum is not available
+func (t *sftpTransfer) remoteSHA256(remotePath string) (string, error) {
+	if !t.noSHA256Sum {
+		if digest, err := t.runSHA256Sum(remotePath); err == nil {
+			return digest, nil
+		}
+		t.noSHA256Sum = true
+	}
+	f, err := t.sftpClient.Open(remotePath)
+	if err != nil {
+		return "", fmt.Errorf("read back %s failed, %s", remotePath, err)
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", fmt.Errorf("read back %s failed, %s", remotePath, err)
+	}
+	return hexDigest(hasher), nil
+}
+
+func (t *sftpTransfer) runSHA256Sum(remotePath string) (string, error) {
+	out, err := t.runOutput("sha256sum -- " + shellQuote(remotePath))
+	if err != nil {
+		return "", err
+	}
+	return parseSHA256Sum(out)
+}
+
+// the digest of the sha256sum output like "<digest>  <file>", it starts with "\" if the file name is escaped
+func parseSHA256Sum(out string) (string, error) {
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("unexpected sha256sum output: %s", out)
+	}
+	digest := strings.TrimPrefix(fields[0], "\\")
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != sha256.Size*2 {
+		return "", fmt.Errorf("unexpected sha256sum output: %s", out)
+	}
+	return digest, nil
+}
+
+// quote the argument for the remote shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,30 @@
+package utils
+
+import "testing"
+
+func TestParseSHA256Sum(t *testing.T) {
+	const digest = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	tests := []struct {
+		in   string
+		want string
+		err  bool
+	}{
+		{digest + "  /tmp/a.txt\n", digest, false},
+		{digest + " */tmp/a.txt\n", digest, false},
+		{"\\" + digest + "  /tmp/a\\nb.txt\n", digest, false},
+		{"", "", true},
+		{"sha256sum: /tmp/a.txt: No such file or directory\n", "", true},
+		{digest[:63] + "  /tmp/a.txt\n", "", true},
+		{"z" + digest[1:] + "  /tmp/a.txt\n", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseSHA256Sum(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("parseSHA256Sum(%q) error = %v, want error %v", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSHA256Sum(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}